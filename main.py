@@ -1,28 +1,263 @@
+import argparse
 import asyncio
+import atexit
+import base64
+import contextlib
 import csv
+import difflib
+import functools
+import hashlib
+import hmac
+import io
 import json
 import logging
+import math
 import os
 import re
+import secrets
+import signal
+import smtplib
+import socket
+import sqlite3
+import subprocess
+import sys
 import threading
+import time
+import traceback
+import tracemalloc
+import unicodedata
+from concurrent.futures import ThreadPoolExecutor
+from datetime import date, datetime, timedelta
+from email.headerregistry import Address
+from email.message import EmailMessage
+from html.parser import HTMLParser
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from logging.handlers import RotatingFileHandler
 from pathlib import Path
-from flask import Flask, jsonify, request, render_template, send_file
+import urllib.error
+import urllib.parse
+import urllib.request
+from urllib.parse import parse_qs, parse_qsl, quote, quote_plus, unquote, urljoin, urlparse
+from flask import Flask, Response, jsonify, request, render_template, send_file, stream_with_context
 from playwright.async_api import async_playwright
 
 # --- CONFIG & CONSTANTS ---
 BASE_DIR = Path(__file__).resolve().parent
-DB_FILE = BASE_DIR / "contacts.csv"
-CFG_FILE = BASE_DIR / "config.json"
+# config.json, or the first config.yaml / config.yml / config.toml found (those allow comments)
+CFG_FILE = next((f for f in (BASE_DIR / f"config.{ext}" for ext in ("json", "yaml", "yml", "toml")) if f.exists()),
+                BASE_DIR / "config.json")
 LOG_FILE = BASE_DIR / "scraper.log"
 
 DEFAULT_CFG = {
-    "search_terms": "Construction", "locations": "Thessaloniki",
-    "headless": True, "max_results": 10, "concurrency": 10
+    "search_terms": "Construction", "locations": "Thessaloniki", "database_path": "contacts.csv",
+    "sources": ["google"], "fallback_source": "", "overpass_url": "https://overpass-api.de/api/interpreter",
+    "yelp_api_key": "", "directory_selectors": {}, "foursquare_api_key": "", "foursquare_categories": {},
+    "tripadvisor_selectors": {},
+    "headless": True, "max_results": 10, "min_rating": 0, "min_reviews": 0,
+    "category_include": [], "category_exclude": [], "website_filter": "", "localized_terms": {}, "areas": {},
+    "maps_zoom": 0, "maps_zoom_overrides": {}, "concurrency": 10, "proxy": "",
+    "block_resources": ["image", "font", "media", "stylesheet"], "maps_xhr": True,
+    "chrome_ws_url": "",
+    "place_timeout_sec": 30, "task_timeout_sec": 600, "max_minutes_per_query": 0, "selector_timeout_sec": 5,
+    "website_timeout_sec": 15,
+    "post_navigation_wait_ms": 2000, "scroll_pause_ms": 1500, "partial_retry_ms": 2000,
+    "dismiss_cookie_banners": True, "ignore_tls_errors": False,
+    "consent_button_texts": ["accept", "accept all", "allow all", "agree", "i agree", "ok", "got it",
+                             "αποδοχή", "αποδοχή όλων", "αποδέχομαι", "συμφωνώ", "akzeptieren", "alle akzeptieren"],
+    "max_pages_per_website": 3, "max_html_kb": 2048, "website_cache_days": 7, "website_cache_dir": ".cache/websites",
+    "website_skip_domains": [], "allowed_tlds": [],
+    "maps_selectors": {}, "selector_failure_threshold": 0.5,
+    "default_country_code": "30", "duplicate_phone_policy": "report", "duplicate_domain_policy": "merge",
+    "duplicate_name_policy": "report", "name_similarity": 0.85, "chain_min_branches": 3, "collapse_chains": False,
+    "contact_keywords": ["contact", "kontakt", "about", "impressum", "επικοινωνια", "σχετικα", "epikoinonia",
+                         "ποιοι ειμαστε", "etaireia"],
+    "legal_keywords": ["privacy", "terms", "impressum", "legal", "gdpr", "datenschutz", "απορρητο", "οροι χρησης",
+                       "προσωπικα δεδομενα"],
+    "notify_desktop": False, "notify_command": "",
+    "smtp_host": "", "smtp_port": 587, "smtp_user": "", "smtp_password": "", "smtp_starttls": True,
+    "smtp_from": "", "send_per_hour": 30, "unsubscribe_url": "", "unsubscribe_email": "",
+    "airtable_api_key": "", "airtable_base_id": "", "airtable_table": "Leads", "airtable_field_map": {},
+    "quality_weights": {"email": 30, "verified": 20, "website": 15, "rating": 15, "reviews": 10, "phone": 10},
+    "retention_days": 0, "retention_action": "delete",
+    "webhook_url": "", "webhook_secret": "", "webhook_batch_size": 100, "webhook_retries": 3,
+    "geocoder": "", "google_maps_api_key": "",
+    "suppression_provider": "", "mailchimp_api_key": "", "mailchimp_list_id": "", "brevo_api_key": "",
+    "guess_emails": True, "verify_guessed_emails": False,
+    "email_verifier": "", "email_verifier_api_key": "",
+    "company_enricher": "", "company_enricher_api_key": "",
+    "smtp_verify_from": "", "smtp_verify_helo": "", "smtp_verify_timeout_sec": 10, "smtp_verify_workers": 8,
+    "dns_resolver": "", "email_verify_batch_size": 100, "email_verify_cache_days": 90,
+    "ocr_images": False, "ocr_max_images": 5, "pdf_max_files": 3, "pdf_max_mb": 5,
+    "rdap_fallback": False, "facebook_pages": False, "facebook_delay_sec": 20,
+    "resource_sample_sec": 15, "resource_warn_rss_mb": 2048, "resource_warn_cpu_percent": 300,
+    "resource_warn_pages": 40,
+    "pause_file": "pause", "otlp_endpoint": "", "profile": "", "profiles": {}
 }
+QUEUE_PREFIX = "scraper"
+CFG_OVERRIDES = {}  # Command-line flags win over config.json
+LEAD_FIELDS = ["Company", "Email", "Email Status", "Email Score", "Phone", "Normalized Phone", "Phone Type", "Website",
+               "Domain", "Facebook", "Instagram", "LinkedIn", "TikTok", "WhatsApp", "Viber", "Telegram", "Category",
+               "Address", "Street", "Number", "Postal Code", "City", "Country", "Latitude", "Longitude",
+               "Rating", "Reviews", "Maps URL", "Source", "Chain ID", "Branches", "Run ID", "Added At", "Checked At",
+               "RDAP Email", "RDAP Role",
+               "Partial", "Raw Company", "TLS Issue", "Final URL", "Redirected",
+               "Parked Domain", "Website Status",
+               "Query", "Quality",
+               "Legal Name", "VAT Number", "Employees", "Enriched At"]
+ADDRESS_FIELDS = ["Street", "Number", "Postal Code", "City", "Country"]
+RETENTION_ACTIONS = ("delete", "anonymize")
+# What retention_action "anonymize" clears: everything that identifies or reaches the business or a person
+PERSONAL_FIELDS = ["Company", "Raw Company", "Email", "Email Status", "Email Score", "Phone", "Normalized Phone",
+                   "Website", "Domain", "Final URL", "Parked Domain", "Facebook", "Instagram", "LinkedIn", "TikTok",
+                   "WhatsApp", "Viber", "Telegram", "Address", "Street", "Number", "Latitude", "Longitude",
+                   "Maps URL", "RDAP Email", "RDAP Role", "Legal Name", "VAT Number"]
+# Each migration upgrades a loaded database (engine.data/emails/phones) by one schema version.
+# Append new ones at the end; never edit or reorder released ones.
+MIGRATIONS = [
+    # 1: baseline, backfill columns missing from files written by older versions
+    lambda db: [r.setdefault(f, "") for r in db.data for f in LEAD_FIELDS],
+    # 2: leads are stamped with the run that found them
+    lambda db: [r.setdefault("Run ID", "") for r in db.data],
+    # 3: registrable domain (eTLD+1) of the website, used for dedup
+    lambda db: [r.update(Domain=registrable_domain(r.get("Website", ""))) for r in db.data],
+    # 4: address split into street, number, postal code, city and country
+    lambda db: [r.update(parse_address(r.get("Address", ""))) for r in db.data],
+    # 5: coordinates, taken from the Maps URL where it has them
+    lambda db: [r.update(zip(("Latitude", "Longitude"), maps_coords(r.get("Maps URL", "")))) for r in db.data],
+    # 6: E.164 phone and mobile/landline type on leads and phone rows
+    lambda db: [r.update(phone_fields(r.get("Phone", ""), db.cfg["default_country_code"])) for r in db.data] +
+               [p.update(Normalized=normalize_phone(p["Phone"], db.cfg["default_country_code"])) for p in db.phones],
+    # 7: leads remember which source found them
+    lambda db: [r.update(Source="Google Maps" if r.get("Maps URL") else "Website List") for r in db.data],
+    # 8: when each lead was last scraped, from the run that found it
+    lambda db: [r.update({"Checked At": next((run["finished_at"] or run["started_at"] for run in db.runs
+                                              if run["id"] == r.get("Run ID")), "")}) for r in db.data],
+    # 9: when each lead was first saved, for delta exports
+    lambda db: [r.update({"Added At": r.get("Checked At", "")}) for r in db.data],
+    # 10: branches of one chain (shared website domain or phone) grouped under a Chain ID
+    lambda db: assign_chains(db.data, db.cfg),
+    # 11: tidy business names, keeping what Google showed in Raw Company
+    lambda db: [r.update({"Raw Company": r.get("Raw Company") or r.get("Company", ""),
+                          "Company": clean_company(r.get("Company", ""), r.get("Category", ""))}) for r in db.data],
+    # 12: leads still without a Checked At (e.g. merged in from older files) take it from their run
+    lambda db: [r.update({"Checked At": next((run["finished_at"] or run["started_at"] for run in db.runs
+                                              if run["id"] == r.get("Run ID")), "")})
+                for r in db.data if not r.get("Checked At")],
+]
+SCHEMA_VERSION = len(MIGRATIONS)
+
+# Child rows point at their business via lead_key(): the Maps URL, or the website for imported leads
+EMAIL_FIELDS = ["Lead", "Email", "Source Page", "Found At", "Guessed"]
+PHONE_FIELDS = ["Lead", "Phone", "Normalized", "Type", "Source Page"]
+SOCIAL_FIELDS = ["Lead", "Network", "URL", "Source Page"]
+VERIFY_FIELDS = ["Email", "Provider", "Status", "Score", "Checked At"]
+SUPPRESSED_FIELDS = ["Email", "Provider", "Reason", "Synced At"]
+ERROR_FIELDS = ["Kind", "URL", "Query", "Error Class", "Error", "Timestamp"]
+SENT_FIELDS = ["Email", "Lead", "Subject", "Status", "Error", "Sent At"]
+CHANGE_FIELDS = ["Lead", "Company", "Field", "Old", "New", "Checked At"]
 
 # Pre-compiled Regex for Performance
-EMAIL_REGEX = re.compile(r"\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Z|a-z]{2,}\b")
-PHONE_REGEX = re.compile(r"\(?\d{3}\)?[-.\s]?\d{3}[-.\s]?\d{4}")
+# Unicode-aware so IDN domains (info@παράδειγμα.ελ, xn--...) match; candidates still go through valid_email()
+EMAIL_REGEX = re.compile(r"[\w.%+-]+@(?:[^\W_](?:[\w-]*[^\W_])?\.)+(?:xn--[a-z0-9-]+|[^\W\d_]{2,})(?![\w-])",
+                         re.IGNORECASE)
+# Greek numbers first (+30/0030 optional, 69x mobile or 2x landline, any grouping), then generic 3-3-4
+PHONE_REGEX = re.compile(r"(?<![\d+])(?:(?:\+|00)\s?30[\s.-]?(?:\(0\)\s?)?)?"
+                         r"(?:69\d(?:[\s.-]?\d){7}|2(?:[\s.-]?\d){9})(?!\d)"
+                         r"|\(?\d{3}\)?[-.\s]?\d{3}[-.\s]?\d{4}")
+# Labels of numbers that look like phones but aren't: VAT (ΑΦΜ), tax office, GEMI, postal code, PO box, IBAN
+NOT_PHONE_LABEL = re.compile(r"(?:α\.?\s?φ\.?\s?μ|vat(?:\s?(?:no|id|el))?|δ\.?\s?ο\.?\s?υ|γ\.?\s?ε\.?\s?μ\.?\s?η|"
+                             r"τ\.?\s?κ|t\.?\s?k|p\.?\s?o\.?\s?box|iban|gr\d{2})[\s.:#-]*$", re.IGNORECASE)
+# Greek postal codes are 5 digits, usually written "546 30", sometimes after "T.K."
+POSTAL_REGEX = re.compile(r"(?:\b(?:τ\.?\s?κ|t\.?\s?k)\.?\s*)?\b(\d{3})\s?(\d{2})\b", re.IGNORECASE)
+STREET_REGEX = re.compile(r"^(?P<street>.*?\D)\s+(?P<number>\d+[^\W\d_]?(?:[-/]\d+[^\W\d_]?)?)$")
+SKIP_DOMAINS = ["google.com", "facebook.com", "instagram.com"]
+# Social profile hosts -> network (also the lead column holding the first profile found);
+# the paths are share buttons, posts and login pages rather than the business's own profile
+SOCIAL_NETWORKS = {"facebook.com": "Facebook", "instagram.com": "Instagram", "linkedin.com": "LinkedIn",
+                   "tiktok.com": "TikTok"}
+SOCIAL_NON_PROFILES = {"sharer", "sharer.php", "share", "share.php", "dialog", "plugins", "tr", "login", "p", "reel",
+                       "explore", "accounts", "shareArticle", "embed", "intent", "tag"}
+# Settings that can change mid-run; the rest (terms, browser, storage) need a restart
+HOT_RELOAD_KEYS = ["max_results", "min_rating", "min_reviews", "max_minutes_per_query", "place_timeout_sec",
+                   "selector_timeout_sec", "website_timeout_sec", "post_navigation_wait_ms", "scroll_pause_ms",
+                   "partial_retry_ms", "max_pages_per_website", "website_skip_domains", "allowed_tlds",
+                   "contact_keywords", "legal_keywords", "maps_selectors", "selector_failure_threshold",
+                   "category_include", "category_exclude", "website_filter"]
+# Google Maps markup; any key can be overridden through the maps_selectors config
+MAPS_SELECTORS = {
+    "result_link": "a.hfpxzc",
+    "name": "h1.DUwDvf",
+    "category": "button.DkEaL",
+    "address": "button[data-item-id='address']",
+    "phone": "button[data-item-id*='phone:tel:']",
+    "website": "a[data-item-id='authority']",
+    "rating": "div.F7nice span span[aria-hidden='true']",
+    "reviews": "div.F7nice span[aria-label*='reviews']",
+    "card_rating": "span.MW4etd",  # on the result list, read for min_rating/min_reviews before opening a place
+    "card_reviews": "span.UY7F9",
+}
+# Selectors every listing should match; a high miss rate means Google changed its markup
+REQUIRED_SELECTORS = ["result_link", "name", "address"]
+# Bing Maps local results carry their details as JSON in a data-entity attribute
+BING_RESULT_SELECTOR = "[data-entity]"
+# Stand-in for pages over max_html_kb: head, links, images, footer and body text rebuilt as small HTML in the browser,
+# so extract() still finds mailto:/tel: links and visible contacts without the whole page crossing into Python
+BOUNDED_HTML_JS = """cap => {
+    const el = (tag, attrs, text, limit) => {
+        const e = document.createElement(tag);
+        for (const [k, v] of Object.entries(attrs)) e.setAttribute(k, v);
+        e.textContent = (text || "").slice(0, limit);
+        return e.outerHTML;
+    };
+    const parts = [(document.head ? document.head.outerHTML : "").slice(0, cap / 10)];
+    for (const f of document.querySelectorAll("footer")) parts.push(el("footer", {}, f.innerText, cap / 10));
+    for (const a of document.querySelectorAll("a[href]"))
+        parts.push(el("a", {href: a.getAttribute("href")}, a.innerText, 200));
+    for (const i of document.querySelectorAll("img[src]")) parts.push(el("img", {src: i.getAttribute("src")}, "", 0));
+    parts.push(el("p", {}, document.body ? document.body.innerText : "", cap / 2));
+    return parts.join("\\n").slice(0, cap);
+}"""
+# "Accept" buttons of common cookie banners (OneTrust, Cookiebot, CookieYes, Complianz, Didomi, Iubenda, Quantcast,
+# Cookie Notice, Borlabs); anything else falls back to a visible button whose text is one of consent_button_texts
+CONSENT_SELECTORS = ["#onetrust-accept-btn-handler", "#CybotCookiebotDialogBodyLevelButtonLevelOptinAllowAll",
+                     "#CybotCookiebotDialogBodyButtonAccept", ".cky-btn-accept", ".cmplz-btn.cmplz-accept",
+                     "#didomi-notice-agree-button", ".iubenda-cs-accept-btn", ".qc-cmp2-summary-buttons [mode=primary]",
+                     "#cn-accept-cookie", "a._brlbs-btn-accept-all"]
+DISMISS_CONSENT_JS = """([selectors, texts]) => {
+    const visible = el => el.offsetParent !== null || getComputedStyle(el).position === "fixed";
+    for (const sel of selectors) {
+        const el = document.querySelector(sel);
+        if (el && visible(el)) { el.click(); return sel; }
+    }
+    const wanted = texts.map(t => t.toLowerCase());
+    for (const el of document.querySelectorAll("button, a[role=button], [role=button], input[type=button]")) {
+        const text = (el.innerText || el.value || "").trim().toLowerCase();
+        if (text && text.length < 40 && wanted.some(t => text === t || text.startsWith(t + " ")) && visible(el)) {
+            el.click();
+            return text;
+        }
+    }
+    return "";
+}"""
+# Listing sources: config name -> Engine method taking (browser, query, limit) and returning False if the search failed
+SOURCES = {"google": "scrape_maps", "bing": "scrape_bing", "osm": "scrape_osm", "yelp": "scrape_yelp",
+           "xo": "scrape_xo", "vrisko": "scrape_vrisko", "places": "search_places",
+           "foursquare": "search_foursquare", "tripadvisor": "scrape_tripadvisor"}
+# TripAdvisor listing pages (hotels, restaurants, attractions); override via tripadvisor_selectors
+TRIPADVISOR_SELECTORS = {"result_link": "a[href*='_Review-']", "name": "h1", "phone": "a[href^='tel:']",
+                         "website": "a[data-encoded-url], a[href*='website'][target='_blank']",
+                         "address": "a[href='#MAPVIEW'], button[data-automation='open-map'] span",
+                         "category": "a[href*='/Restaurants-'], a[href*='/Hotels-']"}
+# Greek yellow pages. Listings are schema.org LocalBusiness cards; selectors can be overridden per site
+# through directory_selectors, e.g. {"xo": {"card": "div.listing"}}
+DIRECTORIES = {
+    "xo": {"label": "xo.gr", "search": "https://www.xo.gr/search/?what={term}&where={location}"},
+    "vrisko": {"label": "vrisko.gr", "search": "https://www.vrisko.gr/search/{term}/{location}/"},
+}
+DIRECTORY_SELECTORS = {"card": "[itemtype*='LocalBusiness']", "name": "[itemprop='name']",
+                       "address": "[itemprop='address']", "category": "[itemprop='description']"}
+# OSM tags that name what a business is; search terms are matched against their values and the name
+OSM_CATEGORY_TAGS = ["amenity", "shop", "craft", "office", "tourism", "healthcare", "leisure"]
 
 # --- LOGGING ---
 class MemoryHandler(logging.Handler):
@@ -35,160 +270,1812 @@ class MemoryHandler(logging.Handler):
         if len(self.buffer) > 100:
             self.buffer.pop(0)
 
+class JsonFormatter(logging.Formatter):
+    def format(self, record):
+        return json.dumps({"time": datetime.fromtimestamp(record.created).isoformat(timespec="milliseconds"),
+                           "level": record.levelname, "message": record.getMessage()}, ensure_ascii=False)
+
+def setup_file_logging(path, fmt="text", max_mb=10, backups=3):
+    """(Re)attaches the size-rotated log file; fmt is "text" or "json"."""
+    global file_handler
+    if file_handler:
+        log.removeHandler(file_handler)
+        file_handler.close()
+    file_handler = RotatingFileHandler(path, maxBytes=int(max_mb * 1024 * 1024), backupCount=backups,
+                                       encoding="utf-8")
+    if fmt == "json":
+        file_handler.setFormatter(JsonFormatter())
+    log.addHandler(file_handler)
+
 log_handler = MemoryHandler()
 log = logging.getLogger("scraper")
 log.setLevel(logging.INFO)
 log.addHandler(log_handler)
-log.addHandler(logging.FileHandler(LOG_FILE))
+file_handler = None
+setup_file_logging(LOG_FILE)
 log.addHandler(logging.StreamHandler())
 logging.getLogger('werkzeug').setLevel(logging.ERROR)
 
+# --- TRACING ---
+tracer = None  # set by setup_tracing() when an OTLP endpoint is configured
+
+def setup_tracing(cfg):
+    """Exports spans over OTLP/HTTP to otlp_endpoint (or OTEL_EXPORTER_OTLP_ENDPOINT), e.g. Jaeger on :4318."""
+    global tracer
+    endpoint = cfg["otlp_endpoint"]
+    if not endpoint and not os.environ.get("OTEL_EXPORTER_OTLP_ENDPOINT"):
+        return
+    from opentelemetry import trace
+    from opentelemetry.exporter.otlp.proto.http.trace_exporter import OTLPSpanExporter
+    from opentelemetry.sdk.resources import Resource
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor
+    exporter = OTLPSpanExporter(endpoint=f"{endpoint.rstrip('/')}/v1/traces") if endpoint else OTLPSpanExporter()
+    provider = TracerProvider(resource=Resource.create({"service.name": "email-scraper"}))
+    provider.add_span_processor(BatchSpanProcessor(exporter))
+    trace.set_tracer_provider(provider)
+    tracer = trace.get_tracer("email-scraper")
+    log.info(f"Tracing to {endpoint or os.environ['OTEL_EXPORTER_OTLP_ENDPOINT']}")
+
+def span(name, **attrs):
+    return tracer.start_as_current_span(name, attributes=attrs) if tracer else contextlib.nullcontext()
+
+def traced(name, attrs=lambda *args: {}):
+    """Runs the decorated (async) function inside a span; attrs maps its arguments to span attributes."""
+    def wrap(fn):
+        if asyncio.iscoroutinefunction(fn):
+            @functools.wraps(fn)
+            async def inner(*args):
+                with span(name, **attrs(*args)):
+                    return await fn(*args)
+        else:
+            @functools.wraps(fn)
+            def inner(*args):
+                with span(name, **attrs(*args)):
+                    return fn(*args)
+        return inner
+    return wrap
+
+# --- PROFILING ---
+def memory_report(limit=40):
+    """Process RSS plus the top Python allocation sites (needs tracemalloc running)."""
+    lines = []
+    with contextlib.suppress(OSError, ValueError):
+        rss = int(Path("/proc/self/statm").read_text().split()[1]) * os.sysconf("SC_PAGE_SIZE")
+        lines.append(f"RSS: {rss / 2 ** 20:.1f} MB")
+    if not tracemalloc.is_tracing():
+        return "\n".join(lines + ["tracemalloc is off; start with --pprof or --memprofile."]) + "\n"
+    current, peak = tracemalloc.get_traced_memory()
+    lines.append(f"Python heap: {current / 2 ** 20:.1f} MB (peak {peak / 2 ** 20:.1f} MB)")
+    for stat in tracemalloc.take_snapshot().statistics("lineno")[:limit]:
+        lines.append(f"{stat.size / 2 ** 20:9.2f} MB {stat.count:9} blocks  {stat.traceback}")
+    return "\n".join(lines) + "\n"
+
+def thread_stacks():
+    names = {t.ident: t.name for t in threading.enumerate()}
+    return "\n".join(f"Thread {names.get(ident, ident)}:\n" + "".join(traceback.format_stack(frame))
+                     for ident, frame in sys._current_frames().items())
+
+def serve_debug(addr):
+    """Plain-text /debug/memory and /debug/stacks on addr (":6060" listens on localhost only)."""
+    host, _, port = addr.rpartition(":")
+
+    class Handler(BaseHTTPRequestHandler):
+        def do_GET(self):
+            pages = {"/debug/memory": memory_report, "/debug/stacks": thread_stacks}
+            if self.path not in pages:
+                return self.send_error(404, "Try /debug/memory or /debug/stacks")
+            body = pages[self.path]().encode()
+            self.send_response(200)
+            self.send_header("Content-Type", "text/plain; charset=utf-8")
+            self.end_headers()
+            self.wfile.write(body)
+
+        def log_message(self, *args):
+            pass
+
+    server = ThreadingHTTPServer((host or "127.0.0.1", int(port)), Handler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+    log.info(f"Debug endpoints on http://{host or '127.0.0.1'}:{port}/debug/memory and /debug/stacks")
+
+# --- EXTRACTION ---
+class PageParser(HTMLParser):
+    """Collects link targets, visible text and h-card/hCard microformats from a page,
+    skipping scripts, styles and templates."""
+    SKIP = {"script", "style", "noscript", "template", "svg"}
+    # Microformat property classes (h-card and the older hCard) -> card field
+    CARD_PROPS = {"p-org": "org", "org": "org", "p-name": "org", "fn": "org",
+                  "p-tel": "tel", "tel": "tel", "u-email": "email", "email": "email"}
+
+    def __init__(self):
+        super().__init__(convert_charrefs=True)
+        self.links, self.link_texts, self.images, self.text, self.footer = [], [], [], [], []
+        self.cards = []  # {"org", "tel", "email"} per h-card / vcard
+        self._skip = self._footer = 0
+        self._in_link = False
+        self._card_tags = []  # [tag, open count, card index] of each h-card being read
+        self._prop = None  # [field, tag, open count, card index, text] of the property being read
+
+    @classmethod
+    def parse(cls, html):
+        page = cls()
+        try:
+            page.feed(html)
+            page.close()
+        except Exception:
+            pass
+        return page
+
+    def handle_starttag(self, tag, attrs):
+        self._card_start(tag, dict(attrs))
+        if tag in self.SKIP:
+            self._skip += 1
+        elif tag == "footer":
+            self._footer += 1
+        elif tag == "a":
+            self.links.append(dict(attrs).get("href") or "")
+            self.link_texts.append("")
+            self._in_link = True
+        elif tag == "img" and dict(attrs).get("src"):
+            self.images.append(dict(attrs)["src"])
+
+    def handle_endtag(self, tag):
+        self._card_end(tag)
+        if tag in self.SKIP and self._skip:
+            self._skip -= 1
+        elif tag == "footer" and self._footer:
+            self._footer -= 1
+        elif tag == "a":
+            self._in_link = False
+
+    def _card_start(self, tag, attrs):
+        classes = (attrs.get("class") or "").split()
+        if "h-card" in classes or "vcard" in classes:
+            self.cards.append({})
+            self._card_tags.append([tag, 1, len(self.cards) - 1])
+        elif self._card_tags and self._card_tags[-1][0] == tag:
+            self._card_tags[-1][1] += 1
+        if self._prop and self._prop[1] == tag:
+            self._prop[2] += 1
+        field = next((self.CARD_PROPS[c] for c in classes if c in self.CARD_PROPS), None)
+        if self._prop or not self._card_tags or not field or tag in ("img", "br", "input", "meta", "link"):
+            return
+        card = self.cards[self._card_tags[-1][2]]
+        href = attrs.get("href") or ""
+        if field in card:
+            return
+        if href.lower().startswith(("mailto:", "tel:")):
+            card[field] = unquote(href.split(":", 1)[1].split("?")[0]).strip()
+        else:
+            self._prop = [field, tag, 1, self._card_tags[-1][2], []]
+
+    def _card_end(self, tag):
+        if self._prop and self._prop[1] == tag:
+            self._prop[2] -= 1
+            if not self._prop[2]:
+                field, _, _, index, text = self._prop
+                self.cards[index][field] = " ".join(text)
+                self._prop = None
+        if self._card_tags and self._card_tags[-1][0] == tag:
+            self._card_tags[-1][1] -= 1
+            if not self._card_tags[-1][1]:
+                self._card_tags.pop()
+
+    def handle_data(self, data):
+        if self._prop and data.strip():
+            self._prop[4].append(data.strip())
+        if not self._skip and data.strip():
+            (self.footer if self._footer else self.text).append(data.strip())
+            if self._in_link:
+                self.link_texts[-1] = f"{self.link_texts[-1]} {data.strip()}".strip()
+
+def messaging_links(links):
+    """WhatsApp, Viber and Telegram contacts among a page's links, in one canonical form each."""
+    found = {}
+    for href in links:
+        url = urlparse(href.strip())
+        host, query = url.netloc.lower().removeprefix("www."), dict(parse_qsl(url.query))
+        if host == "wa.me" or host.endswith("whatsapp.com") or url.scheme == "whatsapp":
+            digits = re.sub(r"\D", "", url.path if host == "wa.me" else query.get("phone", ""))
+            if digits:
+                found.setdefault("WhatsApp", f"https://wa.me/{digits}")
+        elif url.scheme == "viber":
+            digits = re.sub(r"\D", "", query.get("number", ""))
+            if digits:
+                found.setdefault("Viber", f"viber://chat?number=%2B{digits}")
+        elif host in ("t.me", "telegram.me") or url.scheme == "tg":
+            name = (url.path.strip("/").split("/")[0] if url.scheme != "tg" else query.get("domain", "")).lstrip("@")
+            if re.fullmatch(r"\w{5,}", name):
+                found.setdefault("Telegram", f"https://t.me/{name}")
+    return found
+
+def social_links(links):
+    """(network, profile URL) for each business profile linked from a page, in canonical www. form."""
+    found = {}
+    for href in links:
+        url = urlparse(href.strip())
+        host = url.netloc.lower()
+        site = next((h for h in SOCIAL_NETWORKS if host == h or host.endswith(f".{h}")), None)
+        parts = [p for p in url.path.split("/") if p]
+        if not site or not parts or parts[0] in SOCIAL_NON_PROFILES:
+            continue
+        network = SOCIAL_NETWORKS[site]
+        if network == "LinkedIn" and (len(parts) < 2 or parts[0] not in ("company", "in", "school")):
+            continue
+        if network == "TikTok" and not parts[0].startswith("@"):
+            continue
+        if network == "Facebook" and parts[0] == "profile.php":
+            profile = f"profile.php?id={dict(parse_qsl(url.query)).get('id', '')}"
+        else:
+            profile = "/".join(parts[:2] if network == "LinkedIn" else parts[:1])
+        found.setdefault(f"https://www.{site}/{profile}", network)
+    return [(network, u) for u, network in found.items()]
+
+def text_phones(text):
+    """Phone-shaped numbers in free text, minus those labelled as VAT numbers, postal codes and the like."""
+    return [m.group() for m in PHONE_REGEX.finditer(text)
+            if not NOT_PHONE_LABEL.search(text[max(0, m.start() - 20):m.start()])]
+
+def extract(html):
+    """Emails and phones from h-card/hCard microformats first, then mailto:/tel: links, then visible text
+    (footer first); text phones are only used when there are no tel: links. Raw-HTML regex is only a fallback.
+    "names" are the business names the cards give."""
+    page = PageParser.parse(html)
+    text = " ".join(page.footer + page.text)
+
+    emails = [m for card in page.cards for m in EMAIL_REGEX.findall(card.get("email", ""))]
+    emails += [m for href in page.links if href.lower().startswith("mailto:") for m in mailto_addresses(href)]
+    emails += EMAIL_REGEX.findall(text)
+    phones = [p for card in page.cards for p in PHONE_REGEX.findall(card.get("tel", ""))]
+    phones += [unquote(href[4:]).strip() for href in page.links if href.lower().startswith("tel:")]
+    phones = phones or text_phones(text)  # tel: links are unambiguous; text also holds VAT numbers and postcodes
+    return {
+        "emails": list(dict.fromkeys(m.lower() for m in emails or EMAIL_REGEX.findall(html) if valid_email(m))),
+        "phones": list(dict.fromkeys(p for p in phones or PHONE_REGEX.findall(html) if p)),
+        "names": list(dict.fromkeys(card["org"] for card in page.cards if card.get("org"))),
+        "people": staff_names(html),
+        "channels": messaging_links(page.links),
+        "socials": social_links(page.links),
+    }
+
 # --- SCRAPER ENGINE ---
 class Engine:
-    def __init__(self):
+    def __init__(self, cfg=None, read_only=False):
         self.active = False
+        self.read_only = read_only  # migrate in memory only and never write the leads files (merge inputs)
         self.data = []
+        self.emails = []
+        self.phones = []
+        self.socials = []
+        self.verifications = []
+        self.suppressed = []
+        self.errors = []
+        self.cfg = dict(DEFAULT_CFG)
+        self.run_id = ""
+        self.selector_stats = {}
+        self.cache_stats = {"hits": 0, "misses": 0}
+        self.resource_peak = {"rss_mb": 0, "cpu_percent": 0, "pages": 0}
+        self.degraded = []
+        self.queue, self.current_query = [], ""  # queries still to search in this run, and the one in progress
+        self.added_queries = {}  # query -> (term, location) for searches added through /api/queries
+        self.current_place, self.query_stats = "", {}
+        self.paused = False  # set by SIGUSR1, cleared by SIGUSR2
+        self.output = None  # file that receives each finished lead as a JSON line (--output)
+        self.emitted = set()  # lead keys already written to it
+        self._cfg_mtime = None
+        self.db_file = None
+        self.open_db(cfg or load_cfg())
+
+    def open_db(self, cfg):
+        """Switches to the leads file named by cfg (child files sit next to it)."""
+        self.cfg = cfg
+        path = resolve_db_path(cfg)
+        if path == self.db_file:
+            return
+        self.db_file = path
+        self.emails_file = path.with_name(f"{path.stem}_emails.csv")
+        self.phones_file = path.with_name(f"{path.stem}_phones.csv")
+        self.socials_file = path.with_name(f"{path.stem}_socials.csv")
+        self.verifications_file = path.with_name(f"{path.stem}_verifications.csv")
+        self.suppressed_file = path.with_name(f"{path.stem}_suppressed.csv")
+        self.meta_file = path.with_name(f"{path.stem}_meta.json")
+        self.runs_file = path.with_name(f"{path.stem}_runs.json")
+        self.errors_file = path.with_name(f"{path.stem}_errors.csv")
+        self.sent_file = path.with_name(f"{path.stem}_sent.csv")
+        self.changes_file = path.with_name(f"{path.stem}_changes.csv")
         self._load_csv()
 
     def _load_csv(self):
-        if DB_FILE.exists():
-            with open(DB_FILE, "r", encoding="utf-8") as f:
-                self.data = list(csv.DictReader(f))
+        self.data = read_csv(self.db_file)
+        self.emails = read_csv(self.emails_file)
+        self.phones = read_csv(self.phones_file)
+        self.socials = read_csv(self.socials_file)
+        self.verifications = read_csv(self.verifications_file)
+        self.suppressed = read_csv(self.suppressed_file)
+        self.errors = read_csv(self.errors_file)
+        self.sent = read_csv(self.sent_file)
+        self.changes = read_csv(self.changes_file)
+        self.meta = json.loads(self.meta_file.read_text()) if self.meta_file.exists() else {}
+        self.runs = json.loads(self.runs_file.read_text()) if self.runs_file.exists() else []
+        self._migrate()
 
+    def _migrate(self):
+        version = self.meta.get("schema_version", 0)
+        if version > SCHEMA_VERSION:
+            log.warning(f"{self.db_file.name} uses schema v{version}, "
+                        f"newer than this version supports (v{SCHEMA_VERSION}).")
+            return
+        for v in range(version, SCHEMA_VERSION):
+            MIGRATIONS[v](self)
+        self.meta["schema_version"] = SCHEMA_VERSION
+        if version < SCHEMA_VERSION and self.db_file.exists() and not self.read_only:
+            log.info(f"Migrated {self.db_file.name} from schema v{version} to v{SCHEMA_VERSION}.")
+            self.save()
+
+    def clear(self):
+        # The sent log and suppression list survive on purpose so cleared leads can never be emailed twice
+        # or against an opt-out, and verification results are paid for, so they stay cached too
+        for path in (self.db_file, self.emails_file, self.phones_file, self.socials_file, self.meta_file,
+                     self.runs_file, self.errors_file, self.changes_file):
+            if path.exists():
+                path.unlink()
+        self._load_csv()
+
+    def rescore(self):
+        """Recomputes every lead's Quality, so it follows enrichment, verification and quality_weights changes."""
+        for r in self.data:
+            r["Quality"] = quality_score(self.cfg, r)
+
+    @traced("save")
     def save(self):
-        fields = ["Company", "Email", "Phone", "Website", "Category", "Address", "Rating", "Reviews", "Maps URL"]
-        tmp = f"{DB_FILE}.tmp"
-        with open(tmp, "w", newline="", encoding="utf-8") as f:
-            w = csv.DictWriter(f, fieldnames=fields)
+        if self.read_only:
+            return
+        self.rescore()
+        self.db_file.parent.mkdir(parents=True, exist_ok=True)
+        write_csv(self.db_file, LEAD_FIELDS, self.data)
+        write_csv(self.emails_file, EMAIL_FIELDS, self.emails)
+        write_csv(self.phones_file, PHONE_FIELDS, self.phones)
+        write_csv(self.socials_file, SOCIAL_FIELDS, self.socials)
+        write_csv(self.errors_file, ERROR_FIELDS, self.errors)
+        if self.sent:
+            write_csv(self.sent_file, SENT_FIELDS, self.sent)
+        if self.changes:
+            write_csv(self.changes_file, CHANGE_FIELDS, self.changes)
+        if self.verifications:
+            write_csv(self.verifications_file, VERIFY_FIELDS, self.verifications)
+        if self.suppressed:
+            write_csv(self.suppressed_file, SUPPRESSED_FIELDS, self.suppressed)
+        self.meta_file.write_text(json.dumps(self.meta, indent=2))
+        self.runs_file.write_text(json.dumps(self.runs, indent=2, ensure_ascii=False))
+
+    def begin_run(self, cfg, mode, queries):
+        """Records a new run; leads added until finish_run() are stamped with its id."""
+        self.active = True
+        self.cfg = cfg
+        self.open_db(cfg)
+        self.run_id = str(max((int(r["id"]) for r in self.runs), default=0) + 1)
+        self.runs.append({"id": self.run_id, "mode": mode, "status": "running",
+                          "started_at": datetime.now().isoformat(timespec="seconds"), "finished_at": "",
+                          "queries": queries, "config": redact(cfg), "counters": {}})
+        self.selector_stats, self.degraded = {}, []
+        self.cache_stats = {"hits": 0, "misses": 0}
+        self.resource_peak = {"rss_mb": 0, "cpu_percent": 0, "pages": 0}
+        self.current_place, self.query_stats = "", {}
+        self._cfg_mtime = CFG_FILE.stat().st_mtime if CFG_FILE.exists() else None
+        self.save()
+
+    def reload_cfg(self):
+        """Applies safe config file edits between queries, without restarting the run."""
+        mtime = CFG_FILE.stat().st_mtime if CFG_FILE.exists() else None
+        if mtime == self._cfg_mtime:
+            return
+        self._cfg_mtime = mtime
+        fresh = load_cfg()
+        changed = {k: fresh[k] for k in HOT_RELOAD_KEYS if fresh.get(k) != self.cfg.get(k)}
+        if changed:
+            self.cfg = {**self.cfg, **changed}
+            log.info(f"Config reloaded: {', '.join(changed)}")
+
+    def finish_run(self, status):
+        new = [r for r in self.data if r.get("Run ID") == self.run_id]
+        self.runs[-1].update(status=status, finished_at=datetime.now().isoformat(timespec="seconds"), counters={
+            "leads_added": len(new),
+            "with_email": sum(1 for r in new if r.get("Email")),
+            "with_website": sum(1 for r in new if r.get("Website")),
+            "selectors": self.selector_stats,
+            "website_cache": self.cache_stats,
+            "chrome_peak": self.resource_peak,
+            "queries": self.query_stats,
+        })
+        self.active = False
+        assign_chains(self.data, self.cfg)
+        self.save()
+        log.info(f"Job finished. Run #{self.run_id} ({status}) added {len(new)} leads. Website cache: "
+                 f"{self.cache_stats['hits']} hits, {self.cache_stats['misses']} fetches. Chrome peak: "
+                 f"{self.resource_peak['rss_mb']} MB, {self.resource_peak['cpu_percent']}% CPU, "
+                 f"{self.resource_peak['pages']} pages.")
+        self._check_selector_health()
+        if self.cfg["duplicate_name_policy"] == "report":
+            for group in fuzzy_duplicates(self.data, float(self.cfg["name_similarity"])).values():
+                if any(r.get("Run ID") == self.run_id for r in group):
+                    log.info("Probable duplicate: " + " | ".join(f"{r.get('Company')} ({r.get('Address')})"
+                                                                  for r in group))
+        notify(self.cfg, f"Scraper run #{self.run_id} {status}", f"{len(new)} new leads, {len(self.data)} total.",
+               {"SCRAPER_RUN_ID": self.run_id, "SCRAPER_STATUS": status, "SCRAPER_LEADS": str(len(new))})
+
+    def _track(self, key, hit):
+        stats = self.selector_stats.setdefault(key, {"hits": 0, "misses": 0})
+        stats["hits" if hit else "misses"] += 1
+
+    def _check_selector_health(self):
+        threshold = float(self.cfg["selector_failure_threshold"])
+        for key in REQUIRED_SELECTORS:
+            stats = self.selector_stats.get(key, {"hits": 0, "misses": 0})
+            total = stats["hits"] + stats["misses"]
+            if total >= 3 and stats["misses"] / total > threshold:
+                self.degraded.append(key)
+                log.warning(f"DEGRADED: selector '{key}' ({self.sel[key]}) missed {stats['misses']}/{total} times. "
+                            f"Google Maps markup probably changed; override it via maps_selectors.")
+
+    def record_error(self, kind, url, query, exc):
+        """Persists a failure (kind is search, place or website) so retry_failed() can re-attempt it."""
+        self.errors = [e for e in self.errors if (e["Kind"], e["URL"]) != (kind, url)]
+        self.errors.append({"Kind": kind, "URL": url, "Query": query, "Error Class": type(exc).__name__,
+                            "Error": str(exc).splitlines()[0][:300] if str(exc) else "",
+                            "Timestamp": datetime.now().isoformat(timespec="seconds")})
+        log.info(f"Failed {kind} {url}: {type(exc).__name__}")
+
+    def add_lead(self, res):
+        if not meets_rating(self.cfg, res.get("Rating"), res.get("Reviews")):
+            log.info(f"Skipped {res.get('Company')}: below min_rating/min_reviews")
+            return
+        if not category_allowed(self.cfg, res.get("Category", "")):
+            log.info(f"Skipped {res.get('Company')}: category {res.get('Category') or '(none)'} filtered out")
+            return
+        if not passes_website_filter(self.cfg, res.get("Website", "")):
+            log.info(f"Skipped {res.get('Company')}: {'no' if not res.get('Website') else 'has a'} website")
+            return
+        res["Query"] = res.get("Query") or self.current_query
+        res["Raw Company"] = res.get("Company", "")
+        res["Company"] = clean_company(res["Raw Company"], res.get("Category", ""))
+        res["Run ID"] = self.run_id
+        res["Added At"] = res["Checked At"] = datetime.now().isoformat(timespec="seconds")
+        res["Domain"] = registrable_domain(res.get("Website", ""))
+        res.update(parse_address(res.get("Address", "")))
+        if not res.get("Latitude"):
+            res["Latitude"], res["Longitude"] = maps_coords(res.get("Maps URL", ""))
+        res.update(phone_fields(res.get("Phone", ""), self.cfg["default_country_code"]))
+        if self._insert(res):
+            if self.current_query:
+                stats = self.query_stats.setdefault(self.current_query, {"leads": 0, "with_website": 0})
+                stats["leads"] += 1
+                stats["with_website"] += bool(res.get("Website"))
+            if not res.get("Website") or res.get("Email"):
+                self.emit(res)  # nothing left to enrich
+        self.save()
+
+    def _insert(self, res):
+        """Saves res unless duplicate_domain_policy/duplicate_phone_policy merge it into a lead sharing its
+        website domain or phone (a different address makes it a branch instead). Returns whether it was added."""
+        for signal in ("domain", "phone"):
+            policy, key = self.cfg[f"duplicate_{signal}_policy"], duplicate_key(signal, res, self.cfg)
+            twin = next((r for r in self.data if key and duplicate_key(signal, r, self.cfg) == key
+                         and not is_branch(r, res)), None)
+            if twin and policy == "merge":
+                self.merge(twin, res)
+                log.info(f"Merged {res.get('Company')} into {twin.get('Company')} (same {signal} {key})")
+                return False
+            if twin and policy == "report":
+                log.info(f"Possible duplicate: {res.get('Company')} shares {signal} {key} with {twin.get('Company')}")
+        self.data.append(res)
+        return True
+
+    def merge_files(self, paths):
+        """Unions other leads files into this one with the same duplicate rules as scraping. A business in both
+        (same Maps URL or website) is filled in from the other copy. Emails, phones, profiles, the sent log,
+        suppressions and cached verifications come along; run history does not. The inputs are left untouched."""
+        children = [("emails", ("Lead", "Email")), ("phones", ("Lead", "Phone")), ("socials", ("Lead", "URL")),
+                    ("sent", ("Email", "Subject", "Sent At")), ("suppressed", ("Email",)),
+                    ("verifications", ("Email", "Provider"))]
+        for path in paths:
+            src = Engine({**self.cfg, "database_path": str(path)}, read_only=True)
+            for name, key in children:
+                rows, seen = getattr(self, name), {tuple(r.get(k) for k in key) for r in getattr(self, name)}
+                rows += [r for r in getattr(src, name) if tuple(r.get(k) for k in key) not in seen]
+            known, added = {lead_key(r): r for r in self.data}, 0
+            for r in src.data:
+                twin = known.get(lead_key(r))
+                if twin:
+                    twin.update({k: v for k, v in r.items() if v and not twin.get(k)})
+                elif self._insert(r):
+                    known[lead_key(r)] = r
+                    added += 1
+            log.info(f"{path}: {added} of {len(src.data)} leads added, the rest were duplicates.")
+        assign_chains(self.data, self.cfg)
+        self.save()
+        log.info(f"{self.db_file.name} now holds {len(self.data)} leads.")
+
+    def apply_retention(self):
+        """Deletes (retention_action "delete") or strips every contact detail from ("anonymize") leads first saved
+        more than retention_days ago, with their emails, phones and profiles, and removes cached website pages as
+        old. Anonymized leads keep only what stats need (category, city, rating, query, dates). Leads without an
+        Added At (saved before runs were tracked) are left alone. A purged lead's change history, cached
+        verifications and recorded failures go too; the purge itself is logged by key to the changes file. Its
+        sent-log rows keep only a hash of the address (see email_digest), so nobody is emailed twice."""
+        if self.cfg["retention_action"] not in RETENTION_ACTIONS:
+            raise ValueError(f"retention_action must be one of {', '.join(RETENTION_ACTIONS)}, "
+                             f"not {self.cfg['retention_action']!r}")
+        cutoff = datetime.now() - timedelta(days=float(self.cfg["retention_days"]))
+        stamp = cutoff.isoformat(timespec="seconds")
+        old = [r for r in self.data if r.get("Added At") and r["Added At"] < stamp
+               and any(r.get(f) for f in PERSONAL_FIELDS)]
+        keys = {lead_key(r) for r in old}
+        addresses = {r["Email"].lower() for r in old if r.get("Email")} | \
+                    {e["Email"].lower() for e in self.emails if e["Lead"] in keys}
+        urls = keys | {r.get("Website") for r in old if r.get("Website")}
+        self.changes = [c for c in self.changes if c["Lead"] not in keys]
+        self.verifications = [v for v in self.verifications if v["Email"].lower() not in addresses]
+        self.errors = [e for e in self.errors if e["URL"] not in urls]
+        for s in self.sent:
+            if s["Lead"] in keys or s["Email"].lower() in addresses:
+                s.update({"Email": email_digest(s["Email"]), "Lead": "", "Error": ""})
+        now = datetime.now().isoformat(timespec="seconds")
+        for r in old:
+            self.changes.append({"Lead": lead_key(r), "Company": "", "Field": "Retention", "Old": "",
+                                 "New": self.cfg["retention_action"], "Checked At": now})
+            if self.cfg["retention_action"] == "anonymize":
+                r.update(dict.fromkeys(PERSONAL_FIELDS, ""))
+        if self.cfg["retention_action"] != "anonymize":
+            self.data = [r for r in self.data if not any(r is o for o in old)]
+        self.emails = [e for e in self.emails if e["Lead"] not in keys]
+        self.phones = [p for p in self.phones if p["Lead"] not in keys]
+        self.socials = [s for s in self.socials if s["Lead"] not in keys]
+        root = cache_path(self.cfg, "").parent
+        pages = [f for f in root.glob("*.json") if f.stat().st_mtime < cutoff.timestamp()] if root.exists() else []
+        for f in pages:
+            f.unlink()
+        if old:
+            self.save()
+        if old or pages:
+            log.info(f"Retention ({self.cfg['retention_days']} days): {len(old)} leads "
+                     f"{'anonymized' if self.cfg['retention_action'] == 'anonymize' else 'deleted'}, "
+                     f"{len(pages)} cached pages removed (see {self.changes_file.name}).")
+
+    def split_query(self, q):
+        """(term, location) of a query, as given for one added mid-run, else guessed by split_query()."""
+        return self.added_queries.get(q) or split_query(q, self.cfg)
+
+    def progress(self):
+        """Snapshot for live status: per-query counters, what is being scraped now and the latest leads."""
+        new = [r for r in self.data if r.get("Run ID") == self.run_id] if self.active else []
+        return {"running": self.active, "paused": self.paused, "run_id": self.run_id,
+                "current_query": self.current_query, "current_place": self.current_place if self.active else "",
+                "pending_queries": len(self.queue), "queries": self.query_stats,
+                "leads_added": len(new), "with_email": sum(1 for r in new if r.get("Email")),
+                "recent_leads": [{k: r.get(k, "") for k in ("Company", "Email", "Phone", "Website")} for r in new[-5:]]}
+
+    def emit(self, res):
+        """Writes a finished lead to --output, once per lead even if its website is scraped again."""
+        if self.output and lead_key(res) not in self.emitted:
+            self.emitted.add(lead_key(res))
+            print(json.dumps({k: res.get(k, "") for k in LEAD_FIELDS}, ensure_ascii=False), file=self.output,
+                  flush=True)
+
+    def dedupe(self, groups, merge=False, interactive=False, delete=False, signal=""):
+        """Reports duplicate groups; with merge (or per group when interactive), folds each into its oldest lead,
+        or with delete drops the others outright. Every merge and deletion is logged to the changes file.
+        Returns False when the user quit."""
+        merged = deleted = 0
+        stopped = False
+        for key, group in groups.items():
+            print(f"{key}: " + " | ".join(f"{r.get('Company')} ({lead_key(r)})" for r in group))
+            action = "delete" if delete else "merge" if merge else ""
+            if interactive:
+                for r in group:
+                    print(f"    {r.get('Company')} | {r.get('Address')} | {r.get('Phone')} | {r.get('Website')}")
+                answer = input("Merge into the first? [y]es / [d]elete the others / [n]o / [q]uit: ").strip().lower()
+                if answer.startswith("q"):
+                    stopped = True
+                    break
+                action = {"y": "merge", "d": "delete"}.get(answer[:1], "")
+            if not action:
+                continue
+            for dup in group[1:]:
+                self._record_change(dup, f"Duplicate ({signal or 'dedupe'} {key})", lead_key(dup),
+                                    "" if action == "delete" else lead_key(group[0]))
+                if action == "delete":
+                    self.drop(dup)
+                else:
+                    self.merge(group[0], dup)
+            merged += action == "merge"
+            deleted += action == "delete"
+        if merged or deleted:
+            self.save()
+        print(f"{len(groups)} duplicate groups{f', {merged} merged' if merged else ''}"
+              f"{f', {deleted} deleted' if deleted else ''}.")
+        return not stopped
+
+    def drop(self, lead):
+        """Removes a lead and its child rows (emails, phones, profiles)."""
+        key = lead_key(lead)
+        self.data = [r for r in self.data if r is not lead]
+        self.emails = [e for e in self.emails if e["Lead"] != key]
+        self.phones = [p for p in self.phones if p["Lead"] != key]
+        self.socials = [s for s in self.socials if s["Lead"] != key]
+
+    def merge(self, keep, dup):
+        """Folds dup into keep: empty fields are filled, child rows re-pointed, dup's key remembered."""
+        for k, v in dup.items():
+            if v and not keep.get(k):
+                keep[k] = v
+        old, new = lead_key(dup), lead_key(keep)
+        for rows, field in [(self.emails, "Email"), (self.phones, "Phone"), (self.socials, "URL")]:
+            have = {r[field] for r in rows if r["Lead"] == new}
+            for r in [r for r in rows if r["Lead"] == old]:
+                if r[field] in have:
+                    rows.remove(r)
+                else:
+                    r["Lead"] = new
+        self.data = [r for r in self.data if r is not dup]
+        if old and old != new:
+            self.meta.setdefault("merged", []).append(old)
+
+    def sync_suppressions(self, cfg):
+        """Replaces the cached opt-out/bounce list with the suppression_provider's current one."""
+        provider = cfg["suppression_provider"]
+        try:
+            found = SUPPRESSION_PROVIDERS[provider](cfg)
+        except Exception as e:
+            log.warning(f"Suppression sync with {provider} failed ({e}); using the list from the last sync.")
+            return
+        now = datetime.now().isoformat(timespec="seconds")
+        self.suppressed = [s for s in self.suppressed if s["Provider"] != provider] + [
+            {"Email": email, "Provider": provider, "Reason": reason, "Synced At": now}
+            for email, reason in found.items()]
+        self.save()
+        log.info(f"Synced {len(found)} suppressed addresses from {provider}.")
+
+    def suppress(self, rows, sync=False):
+        """rows minus leads whose email is on the suppression list, logging why each one was left out. With
+        sync the list is first refreshed from the suppression_provider (if one is set)."""
+        if sync and self.cfg["suppression_provider"]:
+            self.sync_suppressions(self.cfg)
+        reasons = {s["Email"]: f"{s['Reason']} ({s['Provider']})" for s in self.suppressed}
+        for r in rows:
+            if r.get("Email", "").lower() in reasons:
+                log.info(f"Excluded {r.get('Company')} <{r['Email']}>: {reasons[r['Email'].lower()]}")
+        return [r for r in rows if r.get("Email", "").lower() not in reasons]
+
+    def export(self, out, since="", collapse=False, preset="", sql="", by_quality=False):
+        """Writes leads added after since (ISO date/time; "" for all) as CSV (Excel for a .xlsx out, Parquet for
+        .parquet) and moves the export watermark. collapse keeps one lead per chain; preset picks a
+        CONTACT_PRESETS column layout and sql (a WHERE clause, see sql_filter) narrows the leads down;
+        by_quality puts the best leads first. Suppressed addresses are synced first and left out."""
+        self.rescore()
+        rows = [r for r in self.data if (r.get("Added At") or "") > since] if since else self.data
+        if sql:
+            rows = sql_filter(rows, sql)
+        rows = self.suppress(rows, sync=True)
+        if collapse:
+            rows = collapse_chains(rows)
+        if by_quality:
+            rows = sorted(rows, key=lambda r: -r["Quality"])
+        fields = LEAD_FIELDS
+        if preset:
+            fields, convert = CONTACT_PRESETS[preset]
+            rows = [convert(r) for r in rows]
+        if out == "-":
+            w = csv.DictWriter(sys.stdout, fieldnames=fields, extrasaction="ignore")
             w.writeheader()
-            w.writerows(self.data)
-        Path(tmp).replace(DB_FILE)
+            w.writerows(rows)
+        elif out.lower().endswith(".xlsx"):
+            write_xlsx(out, rows)
+        elif out.lower().endswith(".parquet"):
+            write_parquet(out, rows)
+            log.info(f"Query it with DuckDB: duckdb -c \"SELECT City, count(*) FROM '{out}' GROUP BY City\"")
+        else:
+            write_csv(Path(out), fields, rows)
+        self.meta["last_export"] = datetime.now().isoformat(timespec="seconds")
+        self.save()
+        log.info(f"Exported {len(rows)} of {len(self.data)} leads{f' added since {since}' if since else ''}.")
+
+    def record_emails(self, res, emails, source):
+        """Keeps every address found for a business; the first one becomes the primary Email."""
+        known = {e["Email"] for e in self.emails if e["Lead"] == lead_key(res)}
+        for email in emails:
+            if email not in known:
+                known.add(email)
+                self.emails.append({"Lead": lead_key(res), "Email": email, "Source Page": source,
+                                    "Found At": datetime.now().isoformat(timespec="seconds")})
+        if emails and not res["Email"]:
+            # an address on the site's own (post-redirect) domain beats a webmaster's or a free mailbox
+            res["Email"] = next((e for e in emails if res.get("Domain") and
+                                 registrable_domain(e.rpartition("@")[2]) == res["Domain"]), emails[0])
+
+    def record_guesses(self, res, people, source):
+        """Keeps pattern guesses for the staff a site names, marked Guessed and never made the primary Email
+        unless verify_guessed_emails confirms one."""
+        known = {e["Email"] for e in self.emails if e["Lead"] == lead_key(res)}
+        for person in people:
+            for email in guess_emails(person, res.get("Domain") or registrable_domain(res["Website"])):
+                if email not in known:
+                    known.add(email)
+                    self.emails.append({"Lead": lead_key(res), "Email": email, "Source Page": source,
+                                        "Found At": datetime.now().isoformat(timespec="seconds"),
+                                        "Guessed": person})
+
+    def verify_emails(self, cfg):
+        """Checks lead emails with the email_verifier provider in batches, reusing cached results younger
+        than email_verify_cache_days, and fills Email Status / Email Score."""
+        provider = cfg["email_verifier"]
+        cutoff = (datetime.now() - timedelta(days=float(cfg["email_verify_cache_days"]))).isoformat()
+        cache = {v["Email"]: v for v in self.verifications if v["Provider"] == provider and v["Checked At"] > cutoff}
+        guesses = {}  # lead without an email -> its guessed addresses, most likely first
+        if cfg["verify_guessed_emails"]:
+            missing = {lead_key(r) for r in self.data if not r.get("Email")}
+            for e in self.emails:
+                if e.get("Guessed") and e["Lead"] in missing:
+                    guesses.setdefault(e["Lead"], []).append(e["Email"])
+        todo = list(dict.fromkeys([r["Email"].lower() for r in self.data if r.get("Email")] +
+                                  [e for found in guesses.values() for e in found]))
+        todo = [e for e in todo if e not in cache]
+        size = max(1, int(cfg["email_verify_batch_size"]))
+        log.info(f"Verifying {len(todo)} emails with {provider} ({len(cache)} cached)...")
+        for i in range(0, len(todo), size):
+            try:
+                results = VERIFIERS[provider](cfg, todo[i:i + size])
+            except Exception as e:
+                log.info(f"{provider} verification failed: {e}")
+                break
+            now = datetime.now().isoformat(timespec="seconds")
+            self.verifications = [v for v in self.verifications if (v["Email"], v["Provider"]) not in
+                                  {(e, provider) for e in results}]
+            for email, (status, score) in results.items():
+                cache[email] = {"Email": email, "Provider": provider, "Status": status, "Score": score,
+                                "Checked At": now}
+                self.verifications.append(cache[email])
+            self.save()
+        for r in self.data:
+            if not r.get("Email"):
+                r["Email"] = next((e for e in guesses.get(lead_key(r), []) if e in cache
+                                   and cache[e]["Status"] == "valid"), "")
+            hit = cache.get(r.get("Email", "").lower())
+            if hit:
+                r["Email Status"], r["Email Score"] = hit["Status"], hit["Score"]
+        self.save()
+
+    def enrich_companies(self, cfg, again=False):
+        """Fills Legal Name, VAT Number and Employees from the company_enricher registry or API for leads not
+        looked up yet (all of them with again), stamping Enriched At even when nothing matched."""
+        provider = cfg["company_enricher"]
+        todo = [r for r in self.data if again or not r.get("Enriched At")]
+        log.info(f"Looking up {len(todo)} companies with {provider}...")
+        found = 0
+        for n, r in enumerate(todo, 1):
+            try:
+                fields = ENRICHERS[provider](cfg, r)
+            except Exception as e:
+                log.info(f"{provider} lookup failed at {r.get('Company')}: {e}")
+                break
+            r.update({k: v for k, v in fields.items() if v})
+            r["Enriched At"] = datetime.now().isoformat(timespec="seconds")
+            found += bool(fields)
+            if n % 50 == 0:
+                self.save()
+        self.save()
+        log.info(f"{found} of {len(todo)} companies found.")
+
+    def record_socials(self, res, socials, source):
+        """Keeps every social profile found for a business; the first per network also fills its lead column."""
+        known = {s["URL"] for s in self.socials if s["Lead"] == lead_key(res)}
+        for network, url in socials:
+            if url not in known:
+                known.add(url)
+                self.socials.append({"Lead": lead_key(res), "Network": network, "URL": url, "Source Page": source})
+            res[network] = res.get(network) or url
+
+    def record_phones(self, res, phones, source):
+        """Keeps every number found for a business (once per E.164 form) with a mobile/landline guess."""
+        cc = self.cfg["default_country_code"]
+        known = {p.get("Normalized") or p["Phone"] for p in self.phones if p["Lead"] == lead_key(res)}
+        for phone in phones:
+            normalized = normalize_phone(phone, cc)
+            if (normalized or phone) not in known:
+                known.add(normalized or phone)
+                self.phones.append({"Lead": lead_key(res), "Phone": phone, "Normalized": normalized,
+                                    "Type": phone_type(phone), "Source Page": source})
+        if phones and not res["Phone"]:
+            res["Phone"] = phones[0]
+            res.update(phone_fields(phones[0], cc))
 
     async def run(self, cfg):
-        self.active = True
+        self.begin_run(cfg, "maps", build_queries(cfg))
         log.info("Starting optimized scraper...")
-        terms = [s.strip() for s in cfg["search_terms"].split(",") if s.strip()]
-        locations = [loc.strip() for loc in cfg["locations"].split(",") if loc.strip()]
-        
-        async with async_playwright() as p:
-            browser = await p.chromium.launch(headless=cfg["headless"])
-            for q in [f"{t} {loc}" for t in terms for loc in locations]:
+        status = "failed"
+        try:
+            async with async_playwright() as p:
+                browser = await self._launch(p, cfg)
+                await self.resume_pending(browser)
+                self.queue = build_queries(cfg)  # /api/queries can add to or cancel from it mid-run
+                self.added_queries = {}
+                while self.queue and self.active:
+                    q = self.current_query = self.queue.pop(0)
+                    self.reload_cfg()
+                    started = time.monotonic()
+                    await self.search_sources(browser, q, int(self.cfg.get("max_results", 10)))
+                    stats = self.query_stats.setdefault(q, {"leads": 0, "with_website": 0})
+                    stats["seconds"] = round(stats.get("seconds", 0) + time.monotonic() - started)
+                self.current_query = ""
+                
+                await self.enrich(browser, [r for r in self.data if r.get("Website") and not r.get("Email")])
+                if cfg["facebook_pages"]:
+                    await self.scrape_facebook(browser, [r for r in self.data
+                                                         if r.get("Facebook") and not r.get("Email")])
+                await browser.close()
+            if cfg["email_verifier"]:
+                self.verify_emails(cfg)
+            if cfg.get("geocoder"):
+                geocode_missing(cfg, self.data)
+                self.save()
+            if cfg.get("airtable_api_key"):
+                try:
+                    push_airtable(cfg, self.suppress(self.data, sync=True))
+                except Exception as e:
+                    log.info(f"Airtable sync failed: {e}")
+            if cfg["webhook_url"]:
+                try:
+                    push_webhook(cfg, self.suppress([r for r in self.data if r.get("Run ID") == self.run_id],
+                                                    sync=True))
+                except Exception as e:
+                    log.info(f"Webhook push failed: {e}")
+            status = "completed" if self.active else "stopped"
+        finally:
+            self.finish_run(status)
+
+    async def scrape_websites(self, cfg, urls):
+        """Runs only the website enrichment over a supplied URL list, skipping Google Maps."""
+        self.begin_run(cfg, "websites", urls)
+        known = {r.get("Domain") for r in self.data}
+        for url in urls:
+            domain = registrable_domain(url)
+            if domain not in known:
+                known.add(domain)
+                self.data.append({"Company": domain, "Email": "", "Phone": "", "Website": url, "Domain": domain,
+                                  "Maps URL": "", "Source": "Website List", "Run ID": self.run_id,
+                                  "Added At": datetime.now().isoformat(timespec="seconds"),
+                                  "Checked At": datetime.now().isoformat(timespec="seconds")})
+        self.save()
+        wanted = {registrable_domain(url) for url in urls}
+        status = "failed"
+        try:
+            async with async_playwright() as p:
+                browser = await self._launch(p, cfg)
+                await self.enrich(browser, [r for r in self.data if r.get("Domain") in wanted and not r.get("Email")])
+                await browser.close()
+            status = "completed" if self.active else "stopped"
+        finally:
+            self.finish_run(status)
+
+    async def retry_failed(self, cfg):
+        """Re-attempts every recorded failure; entries that fail again stay in the errors file."""
+        self.begin_run(cfg, "retry", [])
+        failed = list(self.errors)
+        self.runs[-1]["queries"] = [e["URL"] for e in failed]
+        log.info(f"Retrying {len(failed)} failures...")
+        status = "failed"
+        try:
+            async with async_playwright() as p:
+                browser = await self._launch(p, cfg)
+                ctx = await self._context(browser, maps=True)
+                page = await ctx.new_page()
+                for e in [e for e in failed if e["Kind"] != "website"]:
+                    if not self.active:
+                        break
+                    self.reload_cfg()
+                    self.errors.remove(e)
+                    if e["Kind"] == "search":
+                        source = e["URL"].split(":")[0] if e["URL"].split(":")[0] in SOURCES else "google"
+                        await self.search(source, browser, e["Query"], int(self.cfg.get("max_results", 10)))
+                    elif not self._known(e["URL"]):
+                        await self._scrape_place_into_db(page, e["URL"], e["Query"])
+                await ctx.close()
+
+                urls = {e["URL"] for e in failed if e["Kind"] == "website"}
+                if self.active:
+                    self.errors = [e for e in self.errors if not (e["Kind"] == "website" and e["URL"] in urls)]
+                    await self.enrich(browser, [r for r in self.data
+                                                if r.get("Website") in urls and not r.get("Email")])
+                await browser.close()
+            status = "completed" if self.active else "stopped"
+        finally:
+            self.finish_run(status)
+        log.info(f"{len(self.errors)} failures remain.")
+
+    async def refresh(self, cfg, max_age):
+        """Re-scrapes leads last checked more than max_age ago: the Maps listing (phone, website) and the
+        website (email). Every difference, including a website going offline, lands in the changes file."""
+        cutoff = (datetime.now() - max_age).isoformat(timespec="seconds")
+        stale = [r for r in self.data if (r.get("Checked At") or "") < cutoff]
+        self.begin_run(cfg, "refresh", [lead_key(r) for r in stale])
+        log.info(f"Refreshing {len(stale)} leads last checked before {cutoff[:10]}...")
+        status, changed = "failed", 0
+        try:
+            async with async_playwright() as p:
+                browser = await self._launch(p, cfg)
+                ctx = await self._context(browser, maps=True)
+                page = await ctx.new_page()
+                for r in stale:
+                    if not self.active:
+                        break
+                    fresh = dict(r, Email="")
+                    if r.get("Source", "Google Maps") == "Google Maps" and r.get("Maps URL"):
+                        try:
+                            place = await self.scrape_place(page, r["Maps URL"])
+                            fresh.update(Phone=place["Phone"], Website=place["Website"])
+                        except Exception as e:
+                            self.record_error("place", r["Maps URL"], "refresh", e)
+                    online = await self.scrape_site(browser, fresh, asyncio.Semaphore(1)) \
+                        if fresh["Website"] else True
+                    if not online:
+                        fresh["Email"] = r.get("Email", "")
+                    for field in ("Phone", "Website", "Email", "Website Status"):
+                        if (fresh.get(field) or "") != (r.get(field) or ""):
+                            self._record_change(r, field, r.get(field, ""), fresh.get(field, ""))
+                            changed += 1
+                            r[field] = fresh.get(field, "")
+                    r.update(phone_fields(r.get("Phone", ""), self.cfg["default_country_code"]),
+                             Domain=registrable_domain(r.get("Website", "")),
+                             **{"Checked At": datetime.now().isoformat(timespec="seconds")})
+                    self.save()
+                await ctx.close()
+                await browser.close()
+            status = "completed" if self.active else "stopped"
+        finally:
+            self.finish_run(status)
+        log.info(f"{changed} fields changed on {len(stale)} refreshed leads (see {self.changes_file.name}).")
+
+    def _record_change(self, lead, field, old, new):
+        self.changes.append({"Lead": lead_key(lead), "Company": lead.get("Company", ""), "Field": field,
+                             "Old": old, "New": new, "Checked At": datetime.now().isoformat(timespec="seconds")})
+        log.info(f"{lead.get('Company')}: {field} {old or '-'} -> {new or '-'}")
+
+    async def enrich(self, browser, sites):
+        # High-Concurrency Enrichment
+        if sites and self.active:
+            log.info(f"Enriching {len(sites)} websites...")
+            sem = asyncio.Semaphore(self.cfg.get("concurrency", 10))
+            await asyncio.gather(*[self.scrape_site(browser, r, sem) for r in sites])
+            if self.cfg["rdap_fallback"]:
+                await asyncio.to_thread(self.rdap_contacts, [r for r in sites if not r["Email"]])
+
+    async def scrape_facebook(self, browser, leads):
+        """Public email/phone from the About tab of businesses that only have a Facebook page.
+        One page at a time with facebook_delay_sec between them; stops at the first login wall."""
+        log.info(f"Checking {len(leads)} Facebook pages...")
+        ctx = await self._context(browser)
+        page = await ctx.new_page()
+        try:
+            for i, res in enumerate(leads):
                 if not self.active:
                     break
-                await self.scrape_maps(browser, q, int(cfg.get("max_results", 10)))
-            
-            # High-Concurrency Enrichment
-            sites = [r for r in self.data if r.get("Website") and not r.get("Email")]
-            if sites and self.active:
-                log.info(f"Enriching {len(sites)} websites...")
-                sem = asyncio.Semaphore(cfg.get("concurrency", 10))
-                await asyncio.gather(*[self.scrape_site(browser, r, sem) for r in sites])
-            await browser.close()
-        self.active = False
-        log.info("Job finished.")
+                if i:
+                    await asyncio.sleep(float(self.cfg["facebook_delay_sec"]))
+                try:
+                    await page.goto(f"{res['Facebook']}/about", timeout=self.cfg["website_timeout_sec"] * 1000)
+                except Exception as e:
+                    self.record_error("website", res["Facebook"], "", e)
+                    continue
+                if "/login" in page.url or "checkpoint" in page.url:
+                    log.info("Facebook asks for a login; skipping the remaining pages.")
+                    break
+                found = extract(await self.page_html(page))
+                self.record_emails(res, found["emails"], page.url)
+                self.record_phones(res, found["phones"], page.url)
+                self.save()
+        finally:
+            await ctx.close()
+
+    def rdap_contacts(self, leads):
+        """Registrant/abuse email from RDAP (or WHOIS) for sites without one. Kept apart from Email: it is
+        often a registrar or privacy proxy, so treat it as a lead for manual follow-up only."""
+        cache = {}
+        for r in leads:
+            domain = r.get("Domain") or registrable_domain(r.get("Website", ""))
+            if domain and domain not in cache:
+                try:
+                    cache[domain] = domain_contact(domain)
+                except Exception as e:
+                    log.info(f"RDAP/WHOIS lookup for {domain} failed: {type(e).__name__}")
+                    cache[domain] = ("", "")
+            r["RDAP Email"], r["RDAP Role"] = cache.get(domain, ("", ""))
+        log.info(f"RDAP/WHOIS found {sum(1 for r in leads if r['RDAP Email'])}/{len(leads)} fallback emails.")
+        self.save()
+
+    async def _context(self, browser, maps=False):
+        """New browser context that aborts the resource types in block_resources. Maps keeps its stylesheets:
+        the result list only scrolls with them. Website contexts accept bad certificates with ignore_tls_errors."""
+        ctx = await browser.new_context(viewport={'width': 1200, 'height': 800},
+                                        ignore_https_errors=not maps and bool(self.cfg["ignore_tls_errors"]))
+        blocked = set(cfg_list(self.cfg, "block_resources")) - ({"stylesheet"} if maps else set())
+        if blocked:
+            await ctx.route("**/*", lambda r: r.abort() if r.request.resource_type in blocked else r.continue_())
+        return ctx
+
+    async def _launch(self, p, cfg):
+        if cfg.get("chrome_ws_url"):
+            log.info(f"Connecting to remote browser at {cfg['chrome_ws_url']}")
+            return await p.chromium.connect_over_cdp(cfg["chrome_ws_url"])
+        proxy = {"server": cfg["proxy"]} if cfg.get("proxy") else None
+        browser = await p.chromium.launch(headless=cfg["headless"], proxy=proxy)
+        if float(cfg["resource_sample_sec"]) > 0:
+            asyncio.get_running_loop().create_task(self.monitor_resources(browser))
+        return browser
+
+    async def monitor_resources(self, browser):
+        """Samples Chrome's memory, CPU and open pages until the browser closes, keeping the peaks
+        for the run summary and warning when resource_warn_* thresholds are crossed."""
+        import psutil
+        procs, warned = {}, set()
+        limits = {"rss_mb": self.cfg["resource_warn_rss_mb"], "cpu_percent": self.cfg["resource_warn_cpu_percent"],
+                  "pages": self.cfg["resource_warn_pages"]}
+        while browser.is_connected():
+            rss, cpu = 0, 0.0
+            for child in psutil.Process().children(recursive=True):
+                try:
+                    proc = procs.setdefault(child.pid, child)
+                    cpu += proc.cpu_percent()  # since the previous sample; 0 on the first
+                    rss += proc.memory_info().rss
+                except psutil.Error:
+                    procs.pop(child.pid, None)  # exited between listing and reading
+            sample = {"rss_mb": round(rss / 2 ** 20), "cpu_percent": round(cpu),
+                      "pages": sum(len(ctx.pages) for ctx in browser.contexts)}
+            for key, value in sample.items():
+                self.resource_peak[key] = max(self.resource_peak.get(key, 0), value)
+                if limits[key] and value > float(limits[key]):
+                    if key not in warned:
+                        log.warning(f"Chrome {key} at {value} (threshold {limits[key]})")
+                    warned.add(key)
+                else:
+                    warned.discard(key)
+            await asyncio.sleep(float(self.cfg["resource_sample_sec"]))
+
+    @property
+    def sel(self):
+        return {**MAPS_SELECTORS, **(self.cfg.get("maps_selectors") or {})}
+
+    def _known(self, url):
+        return any(r.get("Maps URL") == url for r in self.data) or url in self.meta.get("merged", [])
+
+    @traced("query", lambda self, browser, q, limit: {"query": q})
+    async def search_sources(self, browser, q, limit):
+        """Runs q on every configured source; a failed search is retried once on fallback_source."""
+        fallback = self.cfg["fallback_source"]
+        for source in cfg_list(self.cfg, "sources") or ["google"]:
+            if not self.active:
+                break
+            if await self.search(source, browser, q, limit) is False and fallback and fallback != source:
+                log.info(f"{source} search failed, falling back to {fallback}")
+                await self.search(fallback, browser, q, limit)
+
+    @traced("search", lambda self, source, browser, q, limit: {"source": source, "query": q})
+    async def search(self, source, browser, q, limit):
+        if source not in SOURCES:
+            log.warning(f"Unknown source '{source}' (choose from {', '.join(SOURCES)})")
+            return False
+        return await getattr(self, SOURCES[source])(browser, q, limit)
 
     async def scrape_maps(self, browser, q, limit):
-        ctx = await browser.new_context(viewport={'width': 1200, 'height': 800})
+        started = time.monotonic()
+        ctx = await self._context(browser, maps=True)
         page = await ctx.new_page()
+        captured = {}  # feature id -> lead fields, from the result list's own XHR responses
+        if self.cfg["maps_xhr"]:
+            page.on("response", lambda resp: self._capture_search_xhr(resp, captured))
         try:
-            log.info(f"Searching: {q}")
-            await page.goto(f"https://www.google.com/maps/search/{q.replace(' ', '+')}", wait_until="domcontentloaded")
-            
-            # Consent Bypass
             try:
-                for sel in ["button[aria-label*='Accept']", "button[aria-label*='agree']", "button[aria-label*='Αποδοχή']"]:
-                    btn = await page.query_selector(sel)
-                    if btn:
-                        await btn.click()
-                        break
-            except Exception:
-                pass
-
-            await asyncio.sleep(2)
-            if "/maps/place/" in page.url:
-                urls = [page.url]
-            else:
-                # Optimized Scrolling
-                last_count = 0
-                for _ in range(20):
-                    await page.mouse.wheel(0, 4000)
-                    await asyncio.sleep(1.5)
-                    found = await page.query_selector_all("a.hfpxzc")
-                    if len(found) == last_count:
-                        break
-                    last_count = len(found)
-                    if limit > 0 and len(found) >= limit:
-                        break
-                
-                links = await page.query_selector_all("a.hfpxzc")
-                urls = []
-                for link in links:
-                    href = await link.get_attribute("href")
-                    if href:
-                        urls.append(href)
-                
-                if limit > 0:
-                    urls = urls[:limit]
+                urls = await self.collect_urls(page, q, limit)
+            except Exception as e:
+                self.record_error("search", q, q, e)
+                self.save()
+                return False
+            log.info(f"Processing {len(urls)} listings ({sum(maps_feature_id(u) in captured for u in urls)} "
+                     f"already parsed from Maps responses)...")
+            budget = float(self.cfg["max_minutes_per_query"]) * 60
+            for i, url in enumerate(urls):
+                if not self.active:
+                    break
+                if budget and time.monotonic() - started > budget:
+                    left = [{"url": u, "query": q} for u in urls[i:] if not self._known(u)]
+                    self.meta.setdefault("pending_places", []).extend(left)
+                    self.save()
+                    log.warning(f"{q}: out of time after {self.cfg['max_minutes_per_query']} min, "
+                                f"{len(left)} listings left for the next run")
+                    break
+                if self._known(url):
+                    continue
+                if maps_feature_id(url) in captured:
+                    if not meets_rating(self.cfg, captured[maps_feature_id(url)]["Rating"],
+                                        captured[maps_feature_id(url)]["Reviews"]):
+                        continue
+                    res = self._place_lead(url, captured[maps_feature_id(url)])
+                    self.add_lead(res)
+                    log.info(f"Captured: {res['Company']}")
+                else:
+                    await self._scrape_place_into_db(page, url, q)
+        finally:
+            await ctx.close()
+
+    async def resume_pending(self, browser):
+        """Scrapes the listings a previous run left behind when a query hit max_minutes_per_query."""
+        pending = self.meta.pop("pending_places", [])
+        if not pending:
+            return
+        log.info(f"Resuming {len(pending)} listings left over from a query that ran out of time...")
+        ctx = await self._context(browser, maps=True)
+        page = await ctx.new_page()
+        try:
+            for i, task in enumerate(pending):
+                if not self.active:
+                    self.meta["pending_places"] = pending[i:]
+                    break
+                if not self._known(task["url"]):
+                    await self._scrape_place_into_db(page, task["url"], task["query"])
+        finally:
+            self.save()
+            await ctx.close()
+
+    async def _capture_search_xhr(self, resp, captured):
+        if "/search?" in resp.url and "tbm=map" in resp.url:
+            try:
+                captured.update(parse_maps_search(await resp.text()))
+            except Exception as e:
+                log.debug(f"Unparseable Maps response: {type(e).__name__}")
+
+    def _place_lead(self, url, fields):
+        """Lead from fields parsed out of Google's own place data instead of the rendered page."""
+        res = {"Email": "", **fields, "Maps URL": url, "Source": "Google Maps"}
+        href = res["Website"]
+        if href and not website_allowed(href, self.cfg):
+            res["Website"] = ""
+            if self.cfg["facebook_pages"] and urlparse(href).netloc.lower().endswith("facebook.com"):
+                res["Facebook"] = href.split("?")[0].rstrip("/")
+        if res["Phone"]:
+            self.record_phones(res, [res["Phone"]], "Google Maps")
+        return res
+
+    async def scrape_bing(self, browser, q, limit):
+        """Bing Maps local results. Details come with the result list, so no listing pages are opened."""
+        log.info(f"Searching Bing Maps: {q}")
+        ctx = await self._context(browser, maps=True)
+        page = await ctx.new_page()
+        try:
+            try:
+                await page.goto(f"https://www.bing.com/maps?q={quote(q)}", wait_until="domcontentloaded",
+                                timeout=self.cfg["place_timeout_sec"] * 1000)
+                await asyncio.sleep(self.cfg["post_navigation_wait_ms"] / 1000)
+                entities = await page.eval_on_selector_all(BING_RESULT_SELECTOR,
+                                                           "els => els.map(e => e.getAttribute('data-entity'))")
+            except Exception as e:
+                self.record_error("search", f"bing:{q}", q, e)
+                self.save()
+                return False
+            added = 0
+            for raw in entities:
+                if not self.active or (limit and added >= limit):
+                    break
+                res = bing_lead(raw, self.cfg)
+                if res and not self._known(res["Maps URL"]):
+                    self.add_lead(res)
+                    added += 1
+                    log.info(f"Captured: {res['Company']} (Bing)")
+        finally:
+            await ctx.close()
 
-            log.info(f"Processing {len(urls)} listings...")
-            for url in urls:
+    async def scrape_osm(self, browser, q, limit):
+        """OpenStreetMap via Overpass: tagged name/website/phone/email, no browser needed."""
+        term, location = self.split_query(q)
+        log.info(f"Searching OpenStreetMap: {term} in {location}")
+        try:
+            elements = await asyncio.to_thread(overpass_search, self.cfg, term, location)
+        except Exception as e:
+            self.record_error("search", f"osm:{q}", q, e)
+            self.save()
+            return False
+        added = 0
+        for el in elements:
+            if not self.active or (limit and added >= limit):
+                break
+            res = osm_lead(el, self.cfg)
+            if res and not self._known(res["Maps URL"]):
+                email = res.pop("_email")
+                self.add_lead(res)
+                if email and valid_email(email):
+                    self.record_emails(res, [email.lower()], "OpenStreetMap")
+                    self.save()
+                added += 1
+                log.info(f"Captured: {res['Company']} (OSM)")
+
+    async def scrape_yelp(self, browser, q, limit):
+        """Yelp Fusion API search; the website, which the API leaves out, is read from each business page."""
+        term, location = self.split_query(q)
+        log.info(f"Searching Yelp: {term} in {location}")
+        try:
+            if not self.cfg["yelp_api_key"]:
+                raise ValueError("yelp_api_key is not set")
+            businesses = await asyncio.to_thread(yelp_search, self.cfg, term, location, limit)
+        except Exception as e:
+            self.record_error("search", f"yelp:{q}", q, e)
+            self.save()
+            return False
+        ctx = await self._context(browser)
+        page = await ctx.new_page()
+        try:
+            for b in businesses:
                 if not self.active:
                     break
-                if any(r.get("Maps URL") == url for r in self.data):
+                res = yelp_lead(b)
+                if self._known(res["Maps URL"]):
                     continue
-                
-                await page.goto(url, wait_until="domcontentloaded")
-                await page.wait_for_selector("h1.DUwDvf", timeout=5000)
-                
-                res = {
-                    "Company": await self._text(page, "h1.DUwDvf"),
-                    "Category": await self._text(page, "button.DkEaL"),
-                    "Address": (await self._text(page, "button[data-item-id='address']")).replace("", "").strip(),
-                    "Phone": (await self._text(page, "button[data-item-id*='phone:tel:']")).replace("", "").strip(),
-                    "Website": "", "Email": "",
-                    "Rating": await self._text(page, "div.F7nice span span[aria-hidden='true']"),
-                    "Reviews": (await self._text(page, "div.F7nice span[aria-label*='reviews']")).strip("()"),
-                    "Maps URL": url
-                }
-                
-                wb_el = await page.query_selector("a[data-item-id='authority']")
-                if wb_el:
-                    href = await wb_el.get_attribute("href")
-                    if href and not any(d in href.lower() for d in ["google.com", "facebook.com", "instagram.com"]):
-                        res["Website"] = href.split("?")[0].rstrip("/")
-                
-                self.data.append(res)
-                log.info(f"Captured: {res['Company']}")
+                try:
+                    await page.goto(res["Maps URL"], timeout=self.cfg["place_timeout_sec"] * 1000)
+                    href = await page.get_attribute("a[href*='/biz_redir']", "href", timeout=2000)
+                    website = parse_qs(urlparse(href or "").query).get("url", [""])[0].split("?")[0].rstrip("/")
+                    res["Website"] = website if website and website_allowed(website, self.cfg) else ""
+                except Exception:
+                    pass  # no website link, or Yelp blocked the page: keep the API data
+                self.add_lead(res)
+                log.info(f"Captured: {res['Company']} (Yelp)")
+        finally:
+            await ctx.close()
+
+    async def search_places(self, browser, q, limit):
+        """Official Google Places API (Text Search, New) with google_maps_api_key: website, phone and rating come
+        back with each result, so the browser is only needed for the websites themselves."""
+        log.info(f"Searching Places API: {q}")
+        try:
+            if not self.cfg["google_maps_api_key"]:
+                raise ValueError("google_maps_api_key is not set")
+            places = await asyncio.to_thread(places_search, self.cfg, q, limit)
+        except Exception as e:
+            self.record_error("search", f"places:{q}", q, e)
+            self.save()
+            return False
+        for p in places:
+            if not self.active:
+                break
+            res = places_lead(p, self.cfg)
+            if not self._known(res["Maps URL"]):
+                self.add_lead(res)
+                log.info(f"Captured: {res['Company']} (Places API)")
+
+    async def search_foursquare(self, browser, q, limit):
+        """Foursquare Places API with foursquare_api_key. Terms listed in foursquare_categories search by
+        category ID (e.g. {"Plumbers": "11145"}), which is more precise than the free-text query."""
+        term, location = self.split_query(q)
+        log.info(f"Searching Foursquare: {term} in {location}")
+        try:
+            if not self.cfg["foursquare_api_key"]:
+                raise ValueError("foursquare_api_key is not set")
+            places = await asyncio.to_thread(foursquare_search, self.cfg, term, location, limit)
+        except Exception as e:
+            self.record_error("search", f"foursquare:{q}", q, e)
+            self.save()
+            return False
+        for p in places:
+            if not self.active:
+                break
+            res = foursquare_lead(p, self.cfg)
+            if not self._known(res["Maps URL"]):
+                email = res.pop("_email")
+                self.add_lead(res)
+                if email and valid_email(email):
+                    self.record_emails(res, [email.lower()], "Foursquare")
+                    self.save()
+                log.info(f"Captured: {res['Company']} (Foursquare)")
+
+    async def scrape_tripadvisor(self, browser, q, limit):
+        """TripAdvisor search, then each hotel/restaurant page for its phone and (encoded) website link."""
+        sel = {**TRIPADVISOR_SELECTORS, **(self.cfg["tripadvisor_selectors"] or {})}
+        log.info(f"Searching TripAdvisor: {q}")
+        ctx = await self._context(browser)
+        page = await ctx.new_page()
+        try:
+            try:
+                await page.goto(f"https://www.tripadvisor.com/Search?q={quote(q)}", wait_until="domcontentloaded",
+                                timeout=self.cfg["place_timeout_sec"] * 1000)
+                await asyncio.sleep(self.cfg["post_navigation_wait_ms"] / 1000)
+                links = await page.eval_on_selector_all(sel["result_link"], "els => els.map(a => a.href)")
+            except Exception as e:
+                self.record_error("search", f"tripadvisor:{q}", q, e)
+                self.save()
+                return False
+            urls = list(dict.fromkeys(h.split("?")[0].split("#")[0] for h in links))
+            for url in urls[:limit] if limit else urls:
+                if not self.active:
+                    break
+                if self._known(url):
+                    continue
+                try:
+                    await page.goto(url, wait_until="domcontentloaded", timeout=self.cfg["place_timeout_sec"] * 1000)
+                    tel = await page.get_attribute(sel["phone"], "href", timeout=2000) \
+                        if await page.query_selector(sel["phone"]) else ""
+                    site = await page.query_selector(sel["website"])
+                    website = tripadvisor_website(await site.get_attribute("data-encoded-url") or
+                                                  await site.get_attribute("href") or "") if site else ""
+                    res = {"Company": (await self._text(page, sel["name"])).strip(), "Email": "",
+                           "Phone": unquote((tel or "")[4:]).strip(),
+                           "Website": website if website and website_allowed(website, self.cfg) else "",
+                           "Category": (await self._text(page, sel["category"])).strip(),
+                           "Address": (await self._text(page, sel["address"])).strip(),
+                           "Maps URL": url, "Source": "TripAdvisor"}
+                except Exception as e:
+                    self.record_error("place", url, q, e)
+                    self.save()
+                    continue
+                if res["Company"]:
+                    self.add_lead(res)
+                    log.info(f"Captured: {res['Company']} (TripAdvisor)")
+        finally:
+            await ctx.close()
+
+    async def scrape_xo(self, browser, q, limit):
+        return await self.scrape_directory("xo", browser, q, limit)
+
+    async def scrape_vrisko(self, browser, q, limit):
+        return await self.scrape_directory("vrisko", browser, q, limit)
+
+    async def scrape_directory(self, site, browser, q, limit):
+        """Listings from a Greek directory's result page, where phone and often email are shown directly."""
+        d = DIRECTORIES[site]
+        sel = {**DIRECTORY_SELECTORS, **(self.cfg["directory_selectors"].get(site) or {})}
+        term, location = self.split_query(q)
+        url = d["search"].format(term=quote(term), location=quote(location))
+        log.info(f"Searching {d['label']}: {term} in {location}")
+        ctx = await self._context(browser)
+        page = await ctx.new_page()
+        try:
+            try:
+                await page.goto(url, wait_until="domcontentloaded", timeout=self.cfg["place_timeout_sec"] * 1000)
+                cards = await page.query_selector_all(sel["card"])
+            except Exception as e:
+                self.record_error("search", f"{site}:{q}", q, e)
+                self.save()
+                return False
+            host, added = urlparse(url).netloc, 0
+            for card in cards:
+                if not self.active or (limit and added >= limit):
+                    break
+                name = (await self._text(card, sel["name"])).strip()
+                if not name:
+                    continue
+                links = await card.eval_on_selector_all("a[href]", "els => els.map(a => a.href)")
+                listing = next((h for h in links if urlparse(h).netloc == host), f"{url}#{quote(name)}")
+                website = next((h.split("?")[0].rstrip("/") for h in links if urlparse(h).scheme in ("http", "https")
+                                and urlparse(h).netloc != host and website_allowed(h, self.cfg)), "")
+                if self._known(listing.split("?")[0]):
+                    continue
+                found = extract(await card.inner_html())
+                res = {"Company": name, "Email": "", "Phone": found["phones"][0] if found["phones"] else "",
+                       "Website": website, "Category": await self._text(card, sel["category"]),
+                       "Address": ", ".join(s.strip() for s in (await self._text(card, sel["address"])).splitlines()
+                                            if s.strip()),
+                       "Maps URL": listing.split("?")[0],
+                       "Source": d["label"]}
+                self.add_lead(res)
+                self.record_emails(res, found["emails"], listing)
+                self.record_phones(res, found["phones"], listing)
                 self.save()
+                added += 1
+                log.info(f"Captured: {name} ({d['label']})")
         finally:
             await ctx.close()
 
+    async def page_html(self, page):
+        """The page's HTML, or a bounded stand-in (BOUNDED_HTML_JS) when it is over max_html_kb."""
+        cap = int(float(self.cfg["max_html_kb"]) * 1024)
+        size = await page.evaluate("() => document.documentElement.outerHTML.length")
+        if not cap or size <= cap:
+            return await page.content()
+        log.info(f"{page.url} is {size // 1024} KB; reading links, footer and text only")
+        return await page.evaluate(BOUNDED_HTML_JS, cap)
+
+    async def dismiss_consent(self, page):
+        """Clicks "Accept" on a cookie banner, which on some sites covers or withholds the contact details."""
+        if not self.cfg["dismiss_cookie_banners"]:
+            return
+        try:
+            clicked = await page.evaluate(DISMISS_CONSENT_JS, [CONSENT_SELECTORS, self.cfg["consent_button_texts"]])
+        except Exception:
+            return
+        if clicked:
+            log.debug(f"Dismissed cookie banner on {page.url} ({clicked})")
+            await asyncio.sleep(0.5)
+
+    async def checkpoint(self):
+        """Waits here while paused (SIGUSR1 or the pause_file exists); the run keeps all its state."""
+        pause_file = BASE_DIR / self.cfg["pause_file"]
+        if self.active and (self.paused or pause_file.exists()):
+            log.info(f"Paused. Send SIGUSR2 or delete {pause_file.name} to resume.")
+            while self.active and (self.paused or pause_file.exists()):
+                await asyncio.sleep(1)
+            log.info("Resumed.")
+
+    @traced("place", lambda self, page, url, q: {"url": url})
+    async def _scrape_place_into_db(self, page, url, q):
+        await self.checkpoint()
+        self.current_place = url
+        try:
+            res = await self.scrape_place(page, url)
+        except Exception as e:
+            self.record_error("place", url, q, e)
+            self.save()
+            return None
+        res["Query"] = q
+        self.add_lead(res)
+        log.info(f"Captured: {res['Company']}")
+        return res
+
+    @traced("maps.results", lambda self, page, q, limit: {"query": q})
+    async def collect_urls(self, page, q, limit):
+        log.info(f"Searching: {q}")
+        url = await asyncio.to_thread(maps_search_url, self.cfg, *self.split_query(q))
+        await page.goto(url, wait_until="domcontentloaded", timeout=self.cfg["place_timeout_sec"] * 1000)
+        
+        # Consent Bypass
+        try:
+            for sel in ["button[aria-label*='Accept']", "button[aria-label*='agree']", "button[aria-label*='Αποδοχή']"]:
+                btn = await page.query_selector(sel)
+                if btn:
+                    await btn.click()
+                    break
+        except Exception:
+            pass
+
+        await asyncio.sleep(self.cfg["post_navigation_wait_ms"] / 1000)
+        if "/maps/place/" in page.url:
+            return [page.url]
+
+        # Optimized Scrolling
+        last_count = 0
+        for _ in range(20):
+            await page.mouse.wheel(0, 4000)
+            await asyncio.sleep(self.cfg["scroll_pause_ms"] / 1000)
+            found = await page.query_selector_all(self.sel["result_link"])
+            if len(found) == last_count:
+                break
+            last_count = len(found)
+            if limit > 0 and len(found) >= limit:
+                break
+        
+        cards = await page.eval_on_selector_all(
+            self.sel["result_link"],
+            """(links, [rating, reviews]) => links.map(a => {
+                const text = s => (a.parentElement.querySelector(s) || {}).textContent || "";
+                return [a.getAttribute("href"), text(rating), text(reviews)];
+            })""", [self.sel["card_rating"], self.sel["card_reviews"]])
+        urls = [href for href, _, _ in cards if href]
+        self._track("result_link", bool(urls))
+        if self.cfg["min_rating"] or self.cfg["min_reviews"]:
+            urls = [href for href, rating, reviews in cards if href and meets_rating(self.cfg, rating, reviews)]
+            log.info(f"{len(cards) - len(urls)} listings below min_rating/min_reviews skipped")
+
+        return urls[:limit] if limit > 0 else urls
+
+    async def scrape_place(self, page, url):
+        """Place details from the listing page. A listing read without a name or address is read once more
+        after partial_retry_ms (the page may still be rendering) and flagged Partial if still incomplete."""
+        await page.goto(url, wait_until="domcontentloaded", timeout=self.cfg["place_timeout_sec"] * 1000)
+        res = await self._read_place(page, url)
+        if res["Company"].strip() and res["Address"].strip():
+            return res
+        await asyncio.sleep(self.cfg["partial_retry_ms"] / 1000)
+        try:
+            retry = await self._read_place(page, url)
+        except Exception:
+            retry = res
+        if retry["Company"].strip() and retry["Address"].strip():
+            return retry
+        missing = "a name" if not retry["Company"].strip() else "an address"
+        log.warning(f"Listing without {missing}, kept as partial: {url}")
+        return {**retry, "Partial": "yes"}
+
+    async def _read_place(self, page, url):
+        """Reads the JSON the page embeds (APP_INITIALIZATION_STATE); the rendered page and maps_selectors are
+        only read when that is missing or unparseable."""
+        try:
+            fields = place_state_info(await page.evaluate(
+                "() => window.APP_INITIALIZATION_STATE && window.APP_INITIALIZATION_STATE[3]"))
+        except Exception:
+            fields = None
+        if fields:
+            return self._place_lead(url, fields)
+        try:
+            await page.wait_for_selector(self.sel["name"], timeout=self.cfg["selector_timeout_sec"] * 1000)
+        except Exception:
+            self._track("name", False)
+            raise
+        
+        res = {
+            "Company": await self._field(page, "name"),
+            "Category": await self._field(page, "category"),
+            "Address": (await self._field(page, "address")).replace("", "").strip(),
+            "Phone": (await self._field(page, "phone")).replace("", "").strip(),
+            "Website": "", "Email": "",
+            "Rating": await self._field(page, "rating"),
+            "Reviews": (await self._field(page, "reviews")).strip("()"),
+            "Maps URL": url, "Source": "Google Maps"
+        }
+        
+        wb_el = await page.query_selector(self.sel["website"])
+        self._track("website", bool(wb_el))
+        if wb_el:
+            href = await wb_el.get_attribute("href")
+            if href and website_allowed(href, self.cfg):
+                res["Website"] = href.split("?")[0].rstrip("/")
+            elif href and self.cfg["facebook_pages"] and urlparse(href).netloc.lower().endswith("facebook.com"):
+                res["Facebook"] = href.split("?")[0].rstrip("/")
+        if res["Phone"]:
+            self.record_phones(res, [res["Phone"]], "Google Maps")
+        return res
+
+    # --- DISTRIBUTED MODE ---
+    async def coordinate(self, cfg, rds):
+        """Expands queries into place URLs and pushes them as tasks for workers. A query that fails is recorded
+        and skipped. When no result arrives for task_timeout_sec, the tasks still pending are queued once more
+        (a worker may have died holding them); after a second silence they are recorded as failed."""
+        self.begin_run(cfg, "distributed", build_queries(cfg))
+        queued = {}  # place URL -> query
+        status = "failed"
+        try:
+            async with async_playwright() as p:
+                browser = await self._launch(p, cfg)
+                ctx = await self._context(browser, maps=True)
+                page = await ctx.new_page()
+                for q in build_queries(cfg):
+                    if not self.active:
+                        break
+                    self.reload_cfg()
+                    try:
+                        urls = await self.collect_urls(page, q, int(self.cfg.get("max_results", 10)))
+                    except Exception as e:
+                        self.record_error("search", q, q, e)
+                        self.save()
+                        continue
+                    for url in urls:
+                        if url not in queued and not self._known(url):
+                            rds.rpush(f"{QUEUE_PREFIX}:tasks", json.dumps({"url": url, "query": q}))
+                            queued[url] = q
+                await browser.close()
+            log.info(f"Queued {len(queued)} place tasks, waiting for workers...")
+
+            pending, requeued, last_result = dict(queued), False, time.monotonic()
+            while pending and self.active:
+                item = await asyncio.to_thread(rds.blpop, f"{QUEUE_PREFIX}:results", 5)
+                if not item:
+                    if time.monotonic() - last_result < float(self.cfg["task_timeout_sec"]):
+                        continue
+                    if requeued:
+                        for url, q in pending.items():
+                            self.record_error("place", url, q, TimeoutError("no worker returned this task"))
+                        self.save()
+                        break
+                    log.info(f"No results for {self.cfg['task_timeout_sec']}s; re-queueing {len(pending)} tasks.")
+                    for url, q in pending.items():
+                        rds.rpush(f"{QUEUE_PREFIX}:tasks", json.dumps({"url": url, "query": q}))
+                    requeued, last_result = True, time.monotonic()
+                    continue
+                last_result = time.monotonic()
+                res = json.loads(item[1])
+                if res.get("Maps URL") not in pending:
+                    continue  # a late duplicate of a re-queued task
+                del pending[res["Maps URL"]]
+                if res.get("error"):
+                    self.errors.append({"Kind": "place", "URL": res["Maps URL"], "Query": res["query"],
+                                        "Error Class": res["error_class"], "Error": res["error"][:300],
+                                        "Timestamp": datetime.now().isoformat(timespec="seconds")})
+                    self.save()
+                    continue
+                self.emails += res.pop("_emails", [])
+                self.phones += res.pop("_phones", [])
+                self.socials += res.pop("_socials", [])
+                self.errors += res.pop("_errors", [])
+                self.add_lead(res)
+                if res.get("Website") and not res.get("Email"):
+                    self.emit(res)  # the worker already enriched it
+                log.info(f"Captured: {res['Company']} ({len(pending)} pending)")
+            status = "completed" if self.active else "stopped"
+        finally:
+            self.finish_run(status)
+
+    async def work(self, cfg, rds):
+        """Consumes place tasks from Redis until stopped, pushing enriched rows back. The coordinator owns the
+        leads files, so a worker keeps each task's rows in memory only and never saves."""
+        self.active = True
+        self.read_only = True
+        self.data = []
+        self.cfg = cfg
+        self._cfg_mtime = CFG_FILE.stat().st_mtime if CFG_FILE.exists() else None
+        sem = asyncio.Semaphore(1)
+        async with async_playwright() as p:
+            browser = await self._launch(p, cfg)
+            ctx = await self._context(browser, maps=True)
+            page = await ctx.new_page()
+            log.info("Worker ready, waiting for tasks...")
+            while self.active:
+                item = await asyncio.to_thread(rds.blpop, f"{QUEUE_PREFIX}:tasks", 5)
+                if not item:
+                    continue
+                self.reload_cfg()
+                await self.checkpoint()
+                task = json.loads(item[1])
+                self.emails, self.phones, self.socials, self.errors, self.changes = [], [], [], [], []
+                try:
+                    res = await self.scrape_place(page, task["url"])
+                    res["Query"] = task["query"]
+                    if res["Website"] and passes_website_filter(self.cfg, res["Website"]):
+                        await self.scrape_site(browser, res, sem)
+                    res["_emails"] = [e for e in self.emails if e["Lead"] == lead_key(res)]
+                    res["_phones"] = [p for p in self.phones if p["Lead"] == lead_key(res)]
+                    res["_socials"] = [s for s in self.socials if s["Lead"] == lead_key(res)]
+                    res["_errors"] = [e for e in self.errors if e["URL"] == res["Website"]]
+                    log.info(f"Captured: {res['Company']}")
+                except Exception as e:
+                    log.info(f"Failed {task['url']}: {e}")
+                    res = {"Maps URL": task["url"], "query": task["query"], "error": str(e),
+                           "error_class": type(e).__name__}
+                rds.rpush(f"{QUEUE_PREFIX}:results", json.dumps(res))
+            await browser.close()
+
+    @traced("website", lambda self, browser, res, sem: {"url": res["Website"]})
     async def scrape_site(self, browser, res, sem):
         async with sem:
+            await self.checkpoint()
             if not self.active:
                 return
-            ctx = await browser.new_context()
-            await ctx.route("**/*.{png,jpg,jpeg,gif,webp,svg,css,woff,woff2}", lambda r: r.abort())
+            self.current_place = res["Website"]
+            ctx = await self._context(browser)
             page = await ctx.new_page()
+            budget = max(1, int(self.cfg["max_pages_per_website"]))
+            queue, visited, images, pdfs, people = [res["Website"]], 0, [], [], {}
             try:
-                await page.goto(res["Website"], timeout=15000)
-                html = await page.content()
-                res["Email"] = self._extract_email(html)
-                if not res["Phone"]:
-                    res["Phone"] = self._extract_phone(html)
-                self.save()
-            except Exception:
-                pass
+                while queue and visited < budget and not res["Email"]:
+                    url = queue.pop(0)
+                    cached = cache_get(self.cfg, url)
+                    self.cache_stats["hits" if cached else "misses"] += 1
+                    if cached:
+                        final_url, html = cached["url"], cached["html"]
+                        if not visited:
+                            res["Website Status"] = "ok"
+                    else:
+                        try:
+                            with span("website.page", url=url):
+                                resp = await page.goto(url, timeout=self.cfg["website_timeout_sec"] * 1000)
+                                if not visited and resp:
+                                    res["TLS Issue"] = tls_issue(await resp.security_details(), urlparse(page.url))
+                                    res["Website Status"] = http_status(resp.status)
+                                await self.dismiss_consent(page)
+                                final_url, html = page.url, await self.page_html(page)
+                        except Exception as e:
+                            if not visited:
+                                res["Website Status"] = website_status(e)
+                            if not visited and "ERR_CERT" in str(e):
+                                res["TLS Issue"] = "invalid certificate"
+                            try:
+                                final_url, html = await self._http_get(ctx, url)
+                                if not visited:
+                                    res["Website Status"] = "ok"
+                                log.info(f"Browser could not open {url} ({type(e).__name__}); read it over plain HTTP")
+                            except Exception:
+                                if not visited:
+                                    raise e
+                                continue
+                        cache_put(self.cfg, url, final_url, html)
+                    if not visited:
+                        self._record_final_url(res, final_url)
+                        if is_parked(html):
+                            log.info(f"{res['Website']} is a parked domain; treating {res['Company']} as website-less")
+                            res["Parked Domain"], res["Website"] = res["Website"], ""
+                            res["Website Status"] = "parked"
+                            break
+                    visited += 1
+                    found = extract(html)
+                    if found["names"] and res.get("Source") == "Website List" and res["Company"] == res["Domain"]:
+                        res["Company"] = found["names"][0]  # the domain was only a placeholder
+                    self.record_emails(res, found["emails"], final_url)
+                    self.record_phones(res, found["phones"], final_url)
+                    self.record_socials(res, found["socials"], final_url)
+                    people.update(dict.fromkeys(found["people"], final_url))
+                    for channel, contact in found["channels"].items():
+                        res[channel] = res.get(channel) or contact
+                    parsed = PageParser.parse(html)
+                    links = [(urljoin(final_url, href), text) for href, text in zip(parsed.links, parsed.link_texts)]
+                    pdfs += [u for u, _ in links if urlparse(u).path.lower().endswith(".pdf")]
+                    if visited == 1:
+                        queue += contact_links(final_url, links, self.cfg)
+                    elif self.cfg["ocr_images"]:
+                        images += [urljoin(final_url, src) for src in parsed.images]
+                if queue and not res["Email"]:
+                    log.info(f"Page budget ({budget}) exhausted for {res['Website']}")
+                if pdfs and not res["Email"]:
+                    await self._pdf_contacts(ctx, res, list(dict.fromkeys(pdfs)))
+                if images and not res["Email"]:
+                    await self._ocr_emails(ctx, res, list(dict.fromkeys(images)))
+                if people and not res["Email"] and self.cfg["guess_emails"]:
+                    for person, page_url in people.items():
+                        self.record_guesses(res, [person], page_url)
+            except Exception as e:
+                self.record_error("website", res["Website"], "", e)
+                return False
             finally:
+                self.save()
                 await ctx.close()
+            self.emit(res)
+            return True
 
-    def _extract_email(self, html):
-        m = EMAIL_REGEX.search(html)
-        return m.group(0).lower() if m else ""
+    def _record_final_url(self, res, final_url):
+        """Remembers where the listed website ends up. A move to another domain (a rebrand) becomes the lead's
+        Domain for dedup and email matching; a redirect to Facebook fills the Facebook column instead."""
+        final = final_url.split("#")[0].split("?")[0].rstrip("/")
+        domain = registrable_domain(final)
+        res["Final URL"] = final
+        res["Redirected"] = "yes" if domain != registrable_domain(res["Website"]) else ""
+        if not res["Redirected"]:
+            return
+        if website_allowed(final, self.cfg):
+            res["Domain"] = domain
+            twin = not shared_host(domain) and next((r for r in self.data if r is not res
+                                                     and r.get("Domain") == domain), None)
+            if twin:
+                log.info(f"Possible duplicate: {res.get('Company')} redirects to {domain}, the site of "
+                         f"{twin.get('Company')}")
+        elif domain == "facebook.com" and not res.get("Facebook"):
+            res["Facebook"] = final
 
-    def _extract_phone(self, html):
-        m = PHONE_REGEX.search(html)
-        return m.group(0) if m else ""
+    async def _http_get(self, ctx, url):
+        """Plain HTTP fallback for pages the browser fails to render (TLS errors, renderer crashes, net::ERR_*)."""
+        resp = await ctx.request.get(url, timeout=self.cfg["website_timeout_sec"] * 1000)
+        if not resp.ok:
+            raise RuntimeError(f"HTTP {resp.status}")
+        return resp.url, (await resp.text())[:int(float(self.cfg["max_html_kb"]) * 1024) or None]
+
+    async def _pdf_contacts(self, ctx, res, pdfs):
+        """Brochures and price lists often carry the email the pages don't; reads up to pdf_max_files of them."""
+        cap = float(self.cfg["pdf_max_mb"]) * 1024 * 1024
+        for url in pdfs[:int(self.cfg["pdf_max_files"])]:
+            try:
+                resp = await ctx.request.get(url, timeout=self.cfg["website_timeout_sec"] * 1000)
+                if int(resp.headers.get("content-length") or 0) > cap:
+                    log.info(f"Skipping {url}: larger than {self.cfg['pdf_max_mb']} MB")
+                    continue
+                data = await resp.body()
+                text = await asyncio.to_thread(pdf_text, data) if len(data) <= cap else ""
+            except Exception as e:
+                log.info(f"Reading {url} failed: {type(e).__name__}")
+                continue
+            self.record_emails(res, [m.lower() for m in EMAIL_REGEX.findall(text) if valid_email(m)], url)
+            self.record_phones(res, list(dict.fromkeys(PHONE_REGEX.findall(text))), url)
+            if res["Email"]:
+                log.info(f"Found {res['Email']} in {url}")
+                return
+
+    async def _ocr_emails(self, ctx, res, images):
+        """Last resort for sites that draw their address as an image: OCR the images of the contact pages."""
+        for src in images[:int(self.cfg["ocr_max_images"])]:
+            try:
+                resp = await ctx.request.get(src, timeout=self.cfg["website_timeout_sec"] * 1000)
+                text = await asyncio.to_thread(ocr_text, await resp.body())
+            except Exception as e:
+                log.info(f"OCR of {src} failed: {type(e).__name__}")
+                continue
+            self.record_emails(res, [m.lower() for m in EMAIL_REGEX.findall(text) if valid_email(m)], src)
+            if res["Email"]:
+                log.info(f"Found {res['Email']} in an image on {res['Website']}")
+                return
+
+    async def _field(self, page, key):
+        text = await self._text(page, self.sel[key])
+        self._track(key, bool(text))
+        return text
 
     async def _text(self, page, sel):
         try:
@@ -196,13 +2083,1010 @@ class Engine:
         except Exception:
             return ""
 
-engine = Engine()
-app = Flask(__name__)
+def redact(cfg):
+    """Config snapshot safe to store: credentials are masked."""
+    secret = ("_key", "password", "token", "secret")
+    return {k: "***" if v and k.endswith(secret) else v for k, v in cfg.items()}
+
+def resolve_db_path(cfg):
+    """Expands {date} and {search_term} in database_path; relative paths live next to main.py."""
+    slug = re.sub(r"\W+", "-", cfg["search_terms"].lower()).strip("-")
+    name = cfg["database_path"].replace("{date}", date.today().isoformat()).replace("{search_term}", slug)
+    path = Path(name)
+    return path if path.is_absolute() else BASE_DIR / path
+
+def fold(text):
+    """Lowercases and strips accents so "Επικοινωνία" matches "επικοινωνια"."""
+    return "".join(c for c in unicodedata.normalize("NFD", text.lower()) if not unicodedata.combining(c))
+
+def cfg_list(cfg, key):
+    """Config lists may be JSON arrays or comma-separated strings (as saved from the Settings tab)."""
+    val = cfg.get(key) or []
+    if isinstance(val, str):
+        val = val.split(",")
+    return [v.strip() for v in val if v.strip()]
+
+def contact_links(page_url, links, cfg):
+    """Same-site links whose URL or text looks like a contact page, then legal pages (privacy, terms,
+    imprint), which usually name a data-controller email."""
+    host = urlparse(page_url).netloc
+    found = []
+    for key in ("contact_keywords", "legal_keywords"):
+        keywords = [fold(k) for k in cfg_list(cfg, key)]
+        for href, text in links:
+            href = href.split("#")[0]
+            if urlparse(href).netloc != host or href in found or href.rstrip("/") == page_url.rstrip("/"):
+                continue
+            if any(k in fold(f"{unquote(href)} {text}") for k in keywords):
+                found.append(href)
+    return found
+
+def cache_path(cfg, url):
+    root = Path(cfg["website_cache_dir"])
+    return (root if root.is_absolute() else BASE_DIR / root) / f"{hashlib.sha1(url.encode()).hexdigest()}.json"
+
+def cache_get(cfg, url):
+    """Cached {"url", "html"} for a page fetched less than website_cache_days ago, else None."""
+    path = cache_path(cfg, url)
+    ttl = float(cfg["website_cache_days"]) * 86400
+    if not ttl or not path.exists() or time.time() - path.stat().st_mtime > ttl:
+        return None
+    try:
+        return json.loads(path.read_text(encoding="utf-8"))
+    except ValueError:
+        return None
+
+def cache_put(cfg, url, final_url, html):
+    if float(cfg["website_cache_days"]):
+        path = cache_path(cfg, url)
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_text(json.dumps({"url": final_url, "html": html}), encoding="utf-8")
+
+# Registrar parking and "for sale" landers (GoDaddy, Sedo, ParkingCrew, Bodis, Dan, Afternic, Papaki, Top.Host)
+# Free site builders and blog hosts: a shared domain there says nothing about being the same business
+SHARED_HOSTING_SUFFIXES = ["business.site", "wixsite.com", "blogspot.com", "wordpress.com", "weebly.com",
+                           "godaddysites.com", "jimdosite.com", "webnode.gr", "webnode.page", "site123.me",
+                           "squarespace.com", "github.io", "netlify.app", "vercel.app", "webflow.io"]
+PARKING_HOSTS = re.compile(r"sedoparking\.com|parkingcrew\.net|bodis\.com|img1\.wsimg\.com/parking-lander|"
+                           r"parklogic|above\.com/marketplace|dan\.com/buy-domain|afternic\.com|domainmarket\.com")
+PARKING_PHRASES = re.compile(r"(?:this|the) domain (?:name )?(?:is|may be) for sale|buy this domain|domain parking|"
+                             r"parked free|this domain is parked|το domain (?:αυτό )?(?:πωλείται|είναι προς πώληση)|"
+                             r"parked (?:by|at|with) (?:papaki|top\.host|godaddy)", re.I)
+
+def is_parked(html):
+    """Whether a page is a parking/for-sale lander rather than the business's site. Phrases alone only count
+    on short pages, so a real site mentioning "domain for sale" in an article is not flagged."""
+    if PARKING_HOSTS.search(html):
+        return True
+    return bool(PARKING_PHRASES.search(html)) and len(" ".join(PageParser.parse(html).text)) < 3000
+
+def http_status(code):
+    """Website Status of a page that answered: "ok", or "http_4xx"/"http_5xx" for error pages (still read,
+    since some error pages carry the contact details)."""
+    return f"http_{code // 100}xx" if code >= 400 else "ok"
+
+def website_status(error):
+    """Website Status of a page that could not be opened, from the browser's net::ERR_* code or the error."""
+    text = f"{type(error).__name__} {error}"
+    if m := re.search(r"HTTP (\d{3})", text):
+        return http_status(int(m.group(1)))
+    for marker, status in [("ERR_NAME_NOT_RESOLVED", "dns_error"), ("ERR_NAME_RESOLUTION", "dns_error"),
+                           ("Timeout", "timeout"), ("ERR_TIMED_OUT", "timeout"), ("ERR_CERT", "tls_error"),
+                           ("ERR_SSL", "tls_error"), ("ERR_CONNECTION", "connection_error"),
+                           ("ERR_ADDRESS_UNREACHABLE", "connection_error"), ("ERR_EMPTY_RESPONSE", "connection_error")]:
+        if marker in text:
+            return status
+    return "error"
+
+def tls_issue(details, parsed):
+    """What is wrong with a page's certificate, from Playwright's security details (available even when
+    ignore_tls_errors let the page load): "expired", "self-signed" or ""."""
+    if parsed.scheme != "https" or not details:
+        return ""
+    if details.get("validTo") and details["validTo"] < time.time():
+        return "expired"
+    if details.get("subjectName") and details["subjectName"] == details.get("issuer"):
+        return "self-signed"
+    return ""
+
+def website_allowed(url, cfg):
+    """Rejects social/aggregator domains and, when allowed_tlds is set, other TLDs."""
+    host = urlparse(url).netloc.lower().split(":")[0]
+    skip = SKIP_DOMAINS + [d.lower() for d in cfg_list(cfg, "website_skip_domains")]
+    if any(host == d or host.endswith(f".{d}") for d in skip):
+        return False
+    tlds = [t.lower().lstrip(".") for t in cfg_list(cfg, "allowed_tlds")]
+    return not tlds or any(host.endswith(f".{t}") for t in tlds)
+
+def email_digest(email):
+    """What the sent log keeps of an address after a retention purge: enough to recognise it, not to read it."""
+    return "sha256:" + hashlib.sha256(email.strip().lower().encode()).hexdigest()
+
+def lead_key(res):
+    return res.get("Maps URL") or res.get("Website", "")
+
+def read_websites(path):
+    """Reads URLs from a CSV with a website/url/domain column, or from its first column."""
+    with open(path, "r", encoding="utf-8") as f:
+        rows = list(csv.reader(f))
+    if not rows:
+        return []
+    header = [h.strip().lower() for h in rows[0]]
+    col = next((header.index(h) for h in ("website", "url", "domain") if h in header), None)
+    if col is None:
+        col = 0
+    else:
+        rows = rows[1:]
+    urls = []
+    for row in rows:
+        url = row[col].strip() if len(row) > col else ""
+        if url:
+            url = url if "://" in url else f"https://{url}"
+            urls.append(url.split("?")[0].rstrip("/"))
+    return list(dict.fromkeys(urls))
+
+def normalize_phone(phone, country_code="30"):
+    """E.164 form (+302101234567); national numbers get country_code. Empty if it isn't a plausible number."""
+    digits = re.sub(r"\D", "", phone.replace("(0)", ""))
+    if digits.startswith("00"):
+        digits = digits[2:]
+    elif not phone.strip().startswith("+") and not (digits.startswith(country_code) and len(digits) > 10):
+        digits = country_code + re.sub(r"^0", "", digits)
+    return f"+{digits}" if 8 <= len(digits) <= 15 else ""
+
+@functools.lru_cache(maxsize=1)
+def _suffix_list():
+    import tldextract
+    # bundled Public Suffix List snapshot, no network; private suffixes keep foo.business.site apart from bar's
+    return tldextract.TLDExtract(suffix_list_urls=(), include_psl_private_domains=True)
+
+def bing_lead(raw, cfg):
+    """Lead from a Bing Maps data-entity JSON blob; None for non-business entities."""
+    try:
+        data = json.loads(raw or "{}")
+    except ValueError:
+        return None
+    e, geo = data.get("entity") or {}, data.get("geometry") or {}
+    if not e.get("title") or not e.get("id"):
+        return None
+    website = (e.get("website") or "").split("?")[0].rstrip("/")
+    return {"Company": e["title"], "Email": "", "Phone": e.get("phone") or "",
+            "Website": website if website and website_allowed(website, cfg) else "",
+            "Category": e.get("primaryCategoryName") or "", "Address": e.get("address") or "",
+            "Latitude": str(geo.get("y") or ""), "Longitude": str(geo.get("x") or ""),
+            "Maps URL": f"https://www.bing.com/maps?ss=ypid.{e['id']}", "Source": "Bing Maps"}
+
+def overpass_search(cfg, term, location):
+    """Named OSM features in location's bounding box whose category tag or name matches term."""
+    area = (cfg["areas"] or {}).get(location)
+    if area:
+        bbox = f"(around:{float(area.get('radius_km') or 5) * 1000:.0f},{area['lat']},{area['lng']})"
+    else:
+        hits = http_json("GET", f"https://nominatim.openstreetmap.org/search?format=json&limit=1&q={quote(location)}",
+                         headers={"User-Agent": "maps-lead-scraper"})
+        if not hits:
+            raise ValueError(f"Location not found: {location}")
+        south, north, west, east = hits[0]["boundingbox"]
+        bbox = f"({south},{west},{north},{east})"
+    # "Plumbers" -> craft=plumber, "Car repair" -> shop=car_repair
+    word = re.sub(r"[\"\\]", "", term.lower().removesuffix("s")).replace(" ", "[ _]")
+    query = "[out:json][timeout:60];(" + "".join(
+        f'nwr["{tag}"~"{word}",i]["name"]{bbox};' for tag in OSM_CATEGORY_TAGS) + \
+        f'nwr["name"~"{word}",i][~"^({"|".join(OSM_CATEGORY_TAGS)})$"~"."]{bbox};);out center tags;'
+    req = urllib.request.Request(cfg["overpass_url"], data=urllib.parse.urlencode({"data": query}).encode(),
+                                 headers={"User-Agent": "maps-lead-scraper"})
+    with urllib.request.urlopen(req, timeout=90) as resp:
+        return json.loads(resp.read())["elements"]
+
+def osm_lead(el, cfg):
+    tags = el.get("tags") or {}
+    if not tags.get("name"):
+        return None
+    website = (tags.get("website") or tags.get("contact:website") or "").split("?")[0].rstrip("/")
+    if website and "://" not in website:
+        website = f"https://{website}"
+    street = " ".join(filter(None, [tags.get("addr:street"), tags.get("addr:housenumber")]))
+    city = " ".join(filter(None, [tags.get("addr:city"), tags.get("addr:postcode")]))
+    center = el.get("center") or el
+    return {"Company": tags["name"], "Email": "", "_email": tags.get("email") or tags.get("contact:email") or "",
+            "Phone": (tags.get("phone") or tags.get("contact:phone") or "").split(";")[0].strip(),
+            "Website": website if website and website_allowed(website, cfg) else "",
+            "Category": next((tags[t] for t in OSM_CATEGORY_TAGS if tags.get(t)), ""),
+            "Address": ", ".join(filter(None, [street, city])),
+            "Latitude": str(center.get("lat", "")), "Longitude": str(center.get("lon", "")),
+            "Maps URL": f"https://www.openstreetmap.org/{el['type']}/{el['id']}", "Source": "OpenStreetMap"}
+
+PLACES_FIELDS = ["places.id", "places.displayName", "places.formattedAddress", "places.nationalPhoneNumber",
+                 "places.websiteUri", "places.rating", "places.userRatingCount", "places.googleMapsUri",
+                 "places.location", "places.primaryTypeDisplayName", "nextPageToken"]
+
+def places_search(cfg, q, limit):
+    """Text Search (New) results, 20 per page; Google stops at 60."""
+    found, token = [], ""
+    headers = {"X-Goog-Api-Key": cfg["google_maps_api_key"], "X-Goog-FieldMask": ",".join(PLACES_FIELDS)}
+    while True:
+        body = {"textQuery": q, "pageSize": 20, **({"pageToken": token} if token else {})}
+        page = http_json("POST", "https://places.googleapis.com/v1/places:searchText", body, headers)
+        found += page.get("places", [])
+        token = page.get("nextPageToken", "")
+        if not token or (limit and len(found) >= limit):
+            return found[:limit] if limit else found
+
+def places_lead(p, cfg):
+    website = (p.get("websiteUri") or "").split("?")[0].rstrip("/")
+    loc = p.get("location") or {}
+    return {"Company": (p.get("displayName") or {}).get("text", ""), "Email": "",
+            "Phone": p.get("nationalPhoneNumber") or "",
+            "Website": website if website and website_allowed(website, cfg) else "",
+            "Category": (p.get("primaryTypeDisplayName") or {}).get("text", ""),
+            "Address": p.get("formattedAddress") or "", "Rating": str(p.get("rating") or ""),
+            "Reviews": str(p.get("userRatingCount") or ""),
+            "Latitude": str(loc.get("latitude") or ""), "Longitude": str(loc.get("longitude") or ""),
+            "Maps URL": p.get("googleMapsUri") or f"https://www.google.com/maps/place/?q=place_id:{p['id']}",
+            "Source": "Google Places API"}
+
+def foursquare_search(cfg, term, location, limit):
+    categories = {fold(k): v for k, v in (cfg["foursquare_categories"] or {}).items()}.get(fold(term))
+    what = f"categories={quote(str(categories))}" if categories else f"query={quote(term)}"
+    url = (f"https://api.foursquare.com/v3/places/search?{what}&near={quote(location)}&limit={min(limit or 50, 50)}"
+           "&fields=fsq_id,name,location,tel,website,email,categories,geocodes,rating,stats")
+    return http_json("GET", url, headers={"Authorization": cfg["foursquare_api_key"]})["results"]
+
+def foursquare_lead(p, cfg):
+    website = (p.get("website") or "").split("?")[0].rstrip("/")
+    point = (p.get("geocodes") or {}).get("main") or {}
+    return {"Company": p.get("name", ""), "Email": "", "_email": p.get("email") or "", "Phone": p.get("tel") or "",
+            "Website": website if website and website_allowed(website, cfg) else "",
+            "Category": ", ".join(c["name"] for c in p.get("categories", [])),
+            "Address": (p.get("location") or {}).get("formatted_address", ""),
+            "Rating": str(round(p["rating"] / 2, 1)) if p.get("rating") else "",  # 0-10 scale -> 5 stars
+            "Reviews": str((p.get("stats") or {}).get("total_ratings") or ""),
+            "Latitude": str(point.get("latitude") or ""), "Longitude": str(point.get("longitude") or ""),
+            "Maps URL": f"https://foursquare.com/v/{p['fsq_id']}", "Source": "Foursquare"}
+
+def tripadvisor_website(value):
+    """TripAdvisor hides website links as base64("XXX_<url>_YYY") in data-encoded-url."""
+    if value and "://" not in value:
+        try:
+            value = base64.b64decode(value + "=" * (-len(value) % 4)).decode("utf-8", "replace")
+        except ValueError:
+            return ""
+        value = value[value.find("http"):value.rfind("_")] if "http" in value else ""
+    return value.split("?")[0].rstrip("/") if value.startswith("http") and "tripadvisor." not in value else ""
+
+def yelp_search(cfg, term, location, limit):
+    """Businesses from the Yelp Fusion search API, paged 50 at a time (the API stops at 240)."""
+    found, limit = [], min(limit or 240, 240)
+    while len(found) < limit:
+        url = (f"https://api.yelp.com/v3/businesses/search?term={quote(term)}&location={quote(location)}"
+               f"&limit={min(50, limit - len(found))}&offset={len(found)}")
+        page = http_json("GET", url, headers={"Authorization": f"Bearer {cfg['yelp_api_key']}"})["businesses"]
+        found += page
+        if not page:
+            break
+    return found
+
+def yelp_lead(b):
+    loc, coords = b.get("location") or {}, b.get("coordinates") or {}
+    return {"Company": b["name"], "Email": "", "Phone": b.get("display_phone") or b.get("phone") or "",
+            "Website": "", "Category": ", ".join(c["title"] for c in b.get("categories", [])),
+            "Address": ", ".join(loc.get("display_address") or []),
+            "Rating": str(b.get("rating") or ""), "Reviews": str(b.get("review_count") or ""),
+            "Latitude": str(coords.get("latitude") or ""), "Longitude": str(coords.get("longitude") or ""),
+            "Maps URL": (b.get("url") or "").split("?")[0], "Source": "Yelp"}
+
+def registrable_domain(url):
+    """eTLD+1 of a URL (https://www.foo.com.gr/el/home -> foo.com.gr), lowercased; empty for no URL."""
+    host = (urlparse(url if "://" in url else f"//{url}").hostname or "") if url else ""
+    if not host:
+        return ""
+    parts = _suffix_list()(host)
+    return f"{parts.domain}.{parts.suffix}" if parts.domain and parts.suffix else host
+
+def shared_host(domain):
+    """Whether a domain belongs to a free site builder or blog host, where unrelated businesses share it."""
+    return any(domain == s or domain.endswith(f".{s}") for s in SHARED_HOSTING_SUFFIXES)
+
+def duplicate_key(signal, lead, cfg):
+    if signal == "phone":
+        return normalize_phone(lead.get("Phone", ""), cfg["default_country_code"])
+    domain = lead.get("Domain") or registrable_domain(lead.get("Website", ""))
+    return "" if shared_host(domain) else domain
+
+def meets_rating(cfg, rating, reviews):
+    """Whether a listing's rating ("4,6" or "4.6") and review count ("(1.234)") reach min_rating and
+    min_reviews; a listing without ratings counts as 0."""
+    stars = float(m.group().replace(",", ".")) if (m := re.search(r"\d+(?:[.,]\d+)?", rating or "")) else 0
+    count = int(re.sub(r"\D", "", reviews or "") or 0)
+    return stars >= float(cfg["min_rating"] or 0) and count >= int(cfg["min_reviews"] or 0)
+
+def passes_website_filter(cfg, website):
+    """website_filter "required" keeps only listings with a website (email campaigns), "none" only those
+    without one (gold leads for web-design calls), which also skips their website scan; "" keeps both."""
+    mode = cfg.get("website_filter") or ""
+    return mode not in ("required", "none") or bool(website) == (mode == "required")
+
+def category_allowed(cfg, category):
+    """category_exclude wins; with a category_include list only matching categories pass. Both match
+    case- and accent-insensitive substrings, so "parking" also drops "Δημόσιο parking"."""
+    category = fold(category)
+    if any(fold(c) in category for c in cfg_list(cfg, "category_exclude")):
+        return False
+    include = cfg_list(cfg, "category_include")
+    return not include or any(fold(c) in category for c in include)
+
+def is_branch(a, b):
+    """Two listings with different addresses are branches of one business, not duplicates."""
+    return bool(a.get("Address") and b.get("Address")) and fold(a["Address"]) != fold(b["Address"])
+
+def assign_chains(leads, cfg):
+    """Stamps Chain ID (the shared domain, else phone) and Branches on every lead of a chain with at least
+    chain_min_branches listings; other leads get them cleared."""
+    minimum = max(2, int(cfg["chain_min_branches"]))
+    for r in leads:
+        r["Chain ID"] = r["Branches"] = ""
+    for signal in ("domain", "phone"):
+        groups = find_duplicates([r for r in leads if not r["Chain ID"]], lambda r: duplicate_key(signal, r, cfg))
+        for key, group in groups.items():
+            if len(group) >= minimum:
+                for r in group:
+                    r["Chain ID"], r["Branches"] = key, str(len(group))
+
+def collapse_chains(leads):
+    """One lead per chain (its first listing, whose Branches says how many there are); others unchanged."""
+    seen = set()
+    return [r for r in leads if not r.get("Chain ID") or not (r["Chain ID"] in seen or seen.add(r["Chain ID"]))]
+
+def find_duplicates(leads, keyfn):
+    """Groups of two or more leads sharing a non-empty key, in first-seen order."""
+    groups = {}
+    for r in leads:
+        key = keyfn(r)
+        if key:
+            groups.setdefault(key, []).append(r)
+    return {k: g for k, g in groups.items() if len(g) > 1}
+
+def parse_address(address):
+    """Splits a Maps address ("Εγνατίας 10, Θεσσαλονίκη 546 30, Ελλάδα") into ADDRESS_FIELDS.
+    Parts that can't be told apart stay empty."""
+    out = dict.fromkeys(ADDRESS_FIELDS, "")
+    parts = [p.strip() for p in address.split(",") if p.strip()]
+    if not parts:
+        return out
+    postal = next((i for i in range(len(parts) - 1, -1, -1) if POSTAL_REGEX.search(parts[i])), None)
+    if postal is not None:
+        m = POSTAL_REGEX.search(parts[postal])
+        out["Postal Code"] = m.group(1) + m.group(2)
+        rest = (parts[postal][:m.start()] + parts[postal][m.end():]).strip(" -")
+        if postal == 0:  # "546 30 Θεσσαλονίκη" on its own, no street
+            out["City"], parts = rest, [""] + parts[1:]
+        else:
+            out["City"] = rest or (parts[postal + 1] if postal + 1 < len(parts) else "")
+        tail = parts[postal + 1 + (not rest):]
+        out["Country"] = tail[-1] if tail and not re.search(r"\d", tail[-1]) else ""
+    elif len(parts) > 1:
+        out["City"], out["Country"] = (parts[-2], parts[-1]) if len(parts) > 2 else (parts[-1], "")
+    street = parts[0]
+    m = STREET_REGEX.match(street) or re.match(r"^(?P<number>\d+\S*)\s+(?P<street>\D.*)$", street)
+    out["Street"], out["Number"] = (m["street"].strip(), m["number"]) if m else (street, "")
+    return out
+
+def dig(data, *path):
+    """data[p0][p1]..., or None where the path doesn't exist."""
+    for key in path:
+        try:
+            data = data[key]
+        except (IndexError, KeyError, TypeError):
+            return None
+    return data
+
+def maps_feature_id(url):
+    m = re.search(r"!1s(0x[0-9a-f]+:0x[0-9a-f]+)", url)
+    return m.group(1) if m else ""
+
+def maps_place_info(info):
+    """Lead fields from the place array Google Maps ships to the browser (search responses and place pages).
+    Positions were worked out from live responses and are the part to fix when Google reshuffles them."""
+    if not isinstance(info, list) or not isinstance(dig(info, 11), str):
+        return None
+    website = dig(info, 7, 0) or ""
+    if website.startswith("/url?"):  # redirect wrapper around the real link
+        website = parse_qs(urlparse(website).query).get("q", [""])[0]
+    return {"Company": dig(info, 11), "Category": ", ".join(dig(info, 13) or []),
+            "Address": dig(info, 39) or ", ".join(dig(info, 2) or []),
+            "Phone": dig(info, 178, 0, 0) or "", "Website": website.split("?")[0].rstrip("/"),
+            "Rating": str(dig(info, 4, 7) or ""), "Reviews": str(dig(info, 4, 8) or ""),
+            "Latitude": str(dig(info, 9, 2) or ""), "Longitude": str(dig(info, 9, 3) or "")}
+
+def parse_maps_json(text):
+    """Maps' XHR/embedded payloads are JSON behind a )]}' guard, sometimes wrapped in {"d": "..."}."""
+    text = text.strip()
+    if text.startswith("{"):
+        text = json.loads(text.removesuffix("/*\"\"*/"))["d"]
+    return json.loads(text.removeprefix(")]}'").strip())
+
+def place_state_info(state):
+    """Lead fields from a place page's APP_INITIALIZATION_STATE[3], whose guarded JSON string holds the
+    place array at index 6."""
+    for blob in state if isinstance(state, list) else []:
+        if isinstance(blob, str) and blob.startswith(")]}'"):
+            try:
+                fields = maps_place_info(dig(parse_maps_json(blob), 6))
+            except ValueError:
+                continue
+            if fields:
+                return fields
+    return None
+
+def parse_maps_search(text):
+    """{feature id: lead fields} for every result in a /search?tbm=map response."""
+    found = {}
+    for entry in dig(parse_maps_json(text), 0, 1) or []:
+        info = dig(entry, 14)
+        fields = maps_place_info(info)
+        if fields and dig(info, 10):
+            found[info[10]] = fields
+    return found
+
+def maps_coords(url):
+    """(lat, lng) strings from a Google Maps place URL (!3d..!4d.. pin, else the @lat,lng viewport)."""
+    m = re.search(r"!3d(-?\d+\.\d+)!4d(-?\d+\.\d+)", url) or re.search(r"@(-?\d+\.\d+),(-?\d+\.\d+)", url)
+    return m.groups() if m else ("", "")
+
+def distance_km(lat1, lng1, lat2, lng2):
+    """Great-circle (haversine) distance."""
+    p1, p2 = math.radians(lat1), math.radians(lat2)
+    a = math.sin((p2 - p1) / 2) ** 2 + math.cos(p1) * math.cos(p2) * math.sin(math.radians(lng2 - lng1) / 2) ** 2
+    return 6371 * 2 * math.asin(math.sqrt(a))
+
+LEGAL_SUFFIXES = {"επε", "ικε", "αε", "οε", "εε", "αβεε", "μικε", "epe", "ike", "ae", "oe", "ee",
+                  "ltd", "llc", "inc", "gmbh", "co", "sa"}
+
+def normalize_name(name):
+    """Folded business name without punctuation or legal suffixes: "ΠΑΠΑΔΟΠΟΥΛΟΣ Α.Ε." -> "παπαδοπουλος"."""
+    words = re.sub(r"[^\w\s]", " ", fold(name).replace(".", "")).split()
+    return " ".join(w for w in words if w not in LEGAL_SUFFIXES)
+
+RATING_SUFFIX = re.compile(r"\s*\d[.,]\d\s*(?:★+\s*)?\(\s*[\d.,]+\s*[kK]?\s*\)\s*$")
+NAME_JUNK = re.compile(r"[\U0001F000-\U0001FAFF\u2600-\u27BF\u2B00-\u2BFF\uFE0F\u200D]")
+
+def clean_company(name, category=""):
+    """Business name as it should read in a greeting: emoji, a trailing rating ("4.7(123)") and a
+    " · Category" suffix Google appends are removed and whitespace is collapsed.
+    "☕ Café Nikos  4.6(230) · Coffee shop" -> "Café Nikos"."""
+    name = NAME_JUNK.sub(" ", name or "")
+    name = re.split(r"\s+[·•]\s+", name)[0]
+    name = RATING_SUFFIX.sub("", name)
+    name = " ".join(name.split()).strip(" -–,")
+    if category and fold(name).endswith(" - " + fold(category)):
+        name = name[:-len(category) - 3].rstrip()
+    return name
+
+def similarity(a, b):
+    return difflib.SequenceMatcher(None, a, b).ratio() if a and b else 0.0
+
+SEARCH_FIELDS = ["Company", "Category", "Address", "City", "Query", "Email", "Website"]
+
+def search_leads(leads, text, limit=20):
+    """Leads matching every word of text, best match first, from an SQLite FTS5 index over SEARCH_FIELDS.
+    Text is folded before indexing (FTS5 keeps Greek accents) and words match as prefixes, so
+    "κομμωτηριο γλυφαδ" finds "Κομμωτήριο Μαρία, Γλυφάδα"."""
+    words = re.findall(r"\w+", fold(text))
+    if not words:
+        return []
+    columns = ", ".join(re.sub(r"\W", "_", f.lower()) for f in SEARCH_FIELDS)
+    db = sqlite3.connect(":memory:")
+    db.execute(f"CREATE VIRTUAL TABLE leads USING fts5({columns})")
+    db.executemany(f"INSERT INTO leads(rowid, {columns}) VALUES (?{', ?' * len(SEARCH_FIELDS)})",
+                   [(i, *(fold(r.get(f) or "") for f in SEARCH_FIELDS)) for i, r in enumerate(leads)])
+    match = " ".join(f'"{w}"*' for w in words)
+    hits = db.execute("SELECT rowid FROM leads WHERE leads MATCH ? ORDER BY rank LIMIT ?", (match, limit))
+    found = [leads[i] for (i,) in hits]
+    db.close()
+    return found
+
+def filter_leads(leads, has_email=False, location="", category=""):
+    """Leads with an email (has_email) in a location (city, address or search query) and category; both
+    match accent- and case-insensitive substrings."""
+    location, category = fold(location), fold(category)
+    return [r for r in leads if (not has_email or r.get("Email"))
+            and (not location or any(location in fold(r.get(f) or "") for f in ("City", "Address", "Query")))
+            and (not category or category in fold(r.get("Category") or ""))]
+
+def print_leads(rows, fmt="table"):
+    if fmt == "json":
+        print(json.dumps([{f: r.get(f, "") for f in LEAD_FIELDS} for r in rows], ensure_ascii=False, indent=2))
+        return
+    for r in rows:
+        print(f"{r.get('Company', '')[:40]:<40} {r.get('Email', '')[:32]:<32} {r.get('Phone', ''):<16} "
+              f"{r.get('Address', '')[:60]}")
+
+DEAD_SITE_STATUSES = {"dns_error", "timeout", "connection_error", "tls_error", "http_5xx", "parked"}
+
+def quality_score(cfg, lead):
+    """0-100 from quality_weights: a found email, a verified one, a working website and a phone count fully,
+    the rating by stars out of 5 and the review count on a log scale reaching full weight at 1000 reviews."""
+    rating = typed_value("Rating", lead.get("Rating")) or 0
+    reviews = typed_value("Reviews", lead.get("Reviews")) or 0
+    signals = {"email": bool(lead.get("Email")), "verified": lead.get("Email Status") == "valid",
+               "website": bool(lead.get("Website")) and lead.get("Website Status", "") not in DEAD_SITE_STATUSES,
+               "rating": min(rating / 5, 1), "reviews": min(math.log10(reviews + 1) / 3, 1),
+               "phone": bool(lead.get("Phone"))}
+    weights = cfg["quality_weights"]
+    total = sum(float(w) for w in weights.values()) or 1
+    return round(100 * sum(float(w) * signals.get(k, 0) for k, w in weights.items()) / total)
+
+def is_gold(lead):
+    """No website, or one that is down: the business needs a web agency most."""
+    return not lead.get("Website") or lead.get("Website Status") in DEAD_SITE_STATUSES
+
+def lead_stats(leads, runs, top=10):
+    """What each search query yields (leads, email hit rate, gold-lead rate: no working website, the best
+    prospects for a web agency, and time spent searching), the most common email domains and run durations."""
+    queries = {}
+    for r in leads:
+        q = queries.setdefault(r.get("Query") or "(unknown)", {"leads": 0, "with_email": 0, "gold": 0, "seconds": 0})
+        q["leads"] += 1
+        q["with_email"] += bool(r.get("Email"))
+        q["gold"] += is_gold(r)
+    for run in runs:
+        for query, counters in (run.get("counters") or {}).get("queries", {}).items():
+            if query in queries:
+                queries[query]["seconds"] += counters.get("seconds", 0)
+    for q in queries.values():
+        q["email_rate"] = round(q["with_email"] / q["leads"], 3)
+        q["gold_rate"] = round(q["gold"] / q["leads"], 3)
+    domains = {}
+    for r in leads:
+        if r.get("Email"):
+            domain = r["Email"].rpartition("@")[2].lower()
+            domains[domain] = domains.get(domain, 0) + 1
+    durations = [{"id": run["id"], "mode": run["mode"], "status": run["status"], "started_at": run["started_at"],
+                  "seconds": round((datetime.fromisoformat(run["finished_at"]) -
+                                    datetime.fromisoformat(run["started_at"])).total_seconds())}
+                 for run in runs if run.get("finished_at")]
+    return {"leads": len(leads), "with_email": sum(1 for r in leads if r.get("Email")),
+            "gold": sum(1 for r in leads if is_gold(r)),
+            "queries": dict(sorted(queries.items(), key=lambda kv: -kv[1]["leads"])),
+            "email_domains": dict(sorted(domains.items(), key=lambda kv: -kv[1])[:top]), "runs": durations}
+
+def print_stats(stats):
+    print(f"{stats['leads']} leads, {stats['with_email']} with email, {stats['gold']} gold (no working website)\n")
+    print(f"{'Query':<40} {'Leads':>6} {'Email %':>8} {'Gold %':>7} {'Time':>8}")
+    for query, q in stats["queries"].items():
+        print(f"{query[:40]:<40} {q['leads']:>6} {q['email_rate']:>8.0%} {q['gold_rate']:>7.0%} "
+              f"{timedelta(seconds=q['seconds'])!s:>8}")
+    print(f"\n{'Email domain':<40} {'Leads':>6}")
+    for domain, count in stats["email_domains"].items():
+        print(f"{domain[:40]:<40} {count:>6}")
+    print(f"\n{'Run':<6} {'Started':<20} {'Mode':<11} {'Status':<10} {'Duration':>9}")
+    for run in stats["runs"]:
+        print(f"#{run['id']:<5} {run['started_at']:<20} {run['mode']:<11} {run['status']:<10} "
+              f"{timedelta(seconds=run['seconds'])!s:>9}")
+
+def fuzzy_duplicates(leads, threshold=0.85):
+    """Groups leads whose normalized names (and addresses, when both have one) are at least threshold similar.
+    Only names sharing a first word are compared, which keeps large files fast."""
+    groups, blocks = {}, {}
+    for r in leads:
+        name, addr = normalize_name(r.get("Company", "")), fold(r.get("Address", ""))
+        if not name:
+            continue
+        for head in blocks.setdefault(name.split()[0], []):
+            head_addr = fold(head.get("Address", ""))
+            if similarity(name, normalize_name(head.get("Company", ""))) >= threshold and \
+                    (not addr or not head_addr or similarity(addr, head_addr) >= threshold):
+                groups[id(head)].append(r)
+                break
+        else:
+            blocks[name.split()[0]].append(r)
+            groups[id(r)] = [r]
+    return {normalize_name(g[0].get("Company", "")): g for g in groups.values() if len(g) > 1}
+
+def pdf_text(data):
+    from pypdf import PdfReader
+    return "\n".join(page.extract_text() or "" for page in PdfReader(io.BytesIO(data)).pages)
+
+def ocr_text(data):
+    """Text in an image, via Tesseract (pip install pytesseract pillow, plus the tesseract binary)."""
+    import pytesseract
+    from PIL import Image
+    with Image.open(io.BytesIO(data)) as img:
+        return pytesseract.image_to_string(img.convert("L"))
+
+def mailto_addresses(href):
+    """Recipients of a mailto: link: the comma-separated path plus any to= parameter, without subject/body."""
+    path, _, query = href[len("mailto:"):].partition("?")
+    to = [v for k, v in parse_qsl(query) if k.lower() == "to"]
+    return [a.strip() for part in [unquote(path)] + to for a in part.split(",") if a.strip()]
+
+LD_JSON = re.compile(r"<script[^>]+application/ld\+json[^>]*>(.*?)</script>", re.I | re.S)
+
+def staff_names(html):
+    """Names of the people a page describes in schema.org JSON-LD (Person, or a business's founder/employee)."""
+    names, stack = [], []
+    for block in LD_JSON.findall(html):
+        try:
+            stack.append(json.loads(block))
+        except ValueError:
+            continue
+    while stack:
+        node = stack.pop(0)
+        if isinstance(node, list):
+            stack += node
+        elif isinstance(node, dict):
+            if node.get("@type") == "Person" and isinstance(node.get("name"), str):
+                names.append(" ".join(node["name"].split()))
+            stack += [v for v in node.values() if isinstance(v, (dict, list))]
+    return list(dict.fromkeys(n for n in names if 1 < len(n.split()) <= 4))
+
+GREEK_LATIN = str.maketrans("αβγδεζηικλμνξοπρσςτυφωάέήίόύώϊϋΐΰ", "avgdeziiklmnxoprsstyfoaeiioyoiyiy")
+GREEK_DIGRAPHS = {"ου": "ou", "θ": "th", "χ": "ch", "ψ": "ps"}
+
+def latin_name(word):
+    """A name as it would appear in a mailbox: Greek transliterated (Γιάννης -> giannis), a-z only."""
+    word = word.lower()
+    for greek, latin in GREEK_DIGRAPHS.items():
+        word = word.replace(greek, latin)
+    return re.sub(r"[^a-z]", "", fold(word.translate(GREEK_LATIN)))
+
+# Mailbox patterns, most common first
+EMAIL_PATTERNS = ["{first}.{last}", "{first}", "{f}.{last}", "{f}{last}", "{first}{last}", "{last}"]
+
+def guess_emails(name, domain):
+    """Likely addresses for a person at a domain, most common pattern first: "Νίκος Παππάς", "x.gr" ->
+    nikos.pappas@x.gr, nikos@x.gr, n.pappas@x.gr, ..."""
+    words = [latin_name(w) for w in name.split() if not w.endswith(".")]  # drops "Dr." and initials
+    words = [w for w in words if w]
+    if len(words) < 2 or not domain:
+        return []
+    first, last = words[0], words[-1]
+    return list(dict.fromkeys(p.format(first=first, last=last, f=first[0]) + "@" + domain for p in EMAIL_PATTERNS))
+
+def valid_email(addr):
+    """RFC 5322 addr-spec check (dot-atom local part) with an IDNA-encodable domain."""
+    try:
+        parsed = Address(addr_spec=addr)
+        labels = parsed.domain.encode("idna").decode("ascii").split(".")
+    except (ValueError, UnicodeError, IndexError):
+        return False
+    local = parsed.username
+    return bool(local) and not local.startswith(".") and not local.endswith(".") and ".." not in local \
+        and len(addr) <= 254 and len(labels) > 1 and len(labels[-1]) > 1 \
+        and all(re.fullmatch(r"(?!-)[a-z0-9-]{1,63}(?<!-)", label, re.IGNORECASE) for label in labels)
+
+def phone_fields(phone, country_code="30"):
+    return {"Normalized Phone": normalize_phone(phone, country_code) if phone else "",
+            "Phone Type": phone_type(phone) if phone else ""}
+
+def phone_type(phone):
+    """Guesses the line type from Greek numbering: 69x is mobile, 2xx is landline."""
+    digits = re.sub(r"\D", "", phone.replace("(0)", ""))
+    for prefix in ("0030", "30"):
+        if digits.startswith(prefix) and len(digits) == len(prefix) + 10:
+            digits = digits[len(prefix):]
+    if len(digits) == 10 and digits.startswith("69"):
+        return "mobile"
+    if len(digits) == 10 and digits.startswith("2"):
+        return "landline"
+    return "unknown"
+
+def read_csv(path):
+    if not path.exists():
+        return []
+    with open(path, "r", encoding="utf-8") as f:
+        return list(csv.DictReader(f))
+
+def write_csv(path, fields, rows):
+    tmp = f"{path}.tmp"
+    with open(tmp, "w", newline="", encoding="utf-8") as f:
+        w = csv.DictWriter(f, fieldnames=fields, extrasaction="ignore")
+        w.writeheader()
+        w.writerows(rows)
+    Path(tmp).replace(path)
+
+def lead_notes(r):
+    return "\n".join(f"{k}: {r[k]}" for k in ("Category", "Rating", "Maps URL", "Query") if r.get(k))
+
+GOOGLE_CONTACT_FIELDS = ["Name", "Organization 1 - Name", "E-mail 1 - Type", "E-mail 1 - Value", "Phone 1 - Type",
+                         "Phone 1 - Value", "Address 1 - Type", "Address 1 - Formatted", "Address 1 - Street",
+                         "Address 1 - City", "Address 1 - Postal Code", "Address 1 - Country", "Website 1 - Type",
+                         "Website 1 - Value", "Notes", "Group Membership"]
+
+def google_contact(r):
+    """A lead in the layout of a Google Contacts CSV export, which Google Contacts imports as-is."""
+    return {"Name": r.get("Company", ""), "Organization 1 - Name": r.get("Company", ""),
+            "E-mail 1 - Type": "* Work" if r.get("Email") else "", "E-mail 1 - Value": r.get("Email", ""),
+            "Phone 1 - Type": ("Mobile" if r.get("Phone Type") == "mobile" else "Work") if r.get("Phone") else "",
+            "Phone 1 - Value": r.get("Normalized Phone") or r.get("Phone", ""),
+            "Address 1 - Type": "Work" if r.get("Address") else "", "Address 1 - Formatted": r.get("Address", ""),
+            "Address 1 - Street": " ".join(filter(None, (r.get("Street"), r.get("Number")))),
+            "Address 1 - City": r.get("City", ""), "Address 1 - Postal Code": r.get("Postal Code", ""),
+            "Address 1 - Country": r.get("Country", ""), "Website 1 - Type": "Work" if r.get("Website") else "",
+            "Website 1 - Value": r.get("Website", ""), "Notes": lead_notes(r),
+            "Group Membership": f"* myContacts ::: {r.get('Category') or 'Leads'}"}
+
+OUTLOOK_CONTACT_FIELDS = ["First Name", "Last Name", "Company", "E-mail Address", "E-mail Display Name",
+                          "Business Phone", "Mobile Phone", "Business Street", "Business City",
+                          "Business Postal Code", "Business Country/Region", "Web Page", "Notes", "Categories"]
+
+def outlook_contact(r):
+    """A lead in the layout of Outlook's "Comma Separated Values" contact export, which its import maps
+    without asking."""
+    mobile = r.get("Phone Type") == "mobile"
+    phone = r.get("Normalized Phone") or r.get("Phone", "")
+    return {"First Name": "", "Last Name": "", "Company": r.get("Company", ""), "E-mail Address": r.get("Email", ""),
+            "E-mail Display Name": r.get("Company", "") if r.get("Email") else "",
+            "Business Phone": "" if mobile else phone, "Mobile Phone": phone if mobile else "",
+            "Business Street": " ".join(filter(None, (r.get("Street"), r.get("Number")))),
+            "Business City": r.get("City", ""), "Business Postal Code": r.get("Postal Code", ""),
+            "Business Country/Region": r.get("Country", ""), "Web Page": r.get("Website", ""),
+            "Notes": lead_notes(r), "Categories": r.get("Category", "")}
+
+# export --preset name -> (CSV columns, lead -> row)
+CONTACT_PRESETS = {"google": (GOOGLE_CONTACT_FIELDS, google_contact),
+                   "outlook": (OUTLOOK_CONTACT_FIELDS, outlook_contact)}
+
+LINK_FIELDS = ["Website", "Final URL", "Facebook", "Instagram", "LinkedIn", "TikTok", "Maps URL"]
+
+def write_xlsx(out, rows):
+    """Excel workbook (a path or a binary file) with one sheet per search query: bold frozen header, filters,
+    columns sized to their content, and clickable websites, profiles and emails. Excel reads the Greek text
+    as-is, which it does not do for a UTF-8 CSV."""
+    from openpyxl import Workbook
+    from openpyxl.styles import Font
+    from openpyxl.utils import get_column_letter
+    book = Workbook()
+    book.remove(book.active)
+    groups = {}
+    for r in rows:
+        groups.setdefault(r.get("Query") or "Leads", []).append(r)
+    for query, leads in (groups or {"Leads": []}).items():
+        name = re.sub(r"[\[\]:*?/\\]", " ", query)[:31].strip() or "Leads"
+        while name in book.sheetnames:
+            name = f"{name[:28]} {len(book.sheetnames)}"
+        sheet = book.create_sheet(name)
+        sheet.append(LEAD_FIELDS)
+        for r in leads:
+            sheet.append([r.get(f, "") for f in LEAD_FIELDS])
+        for cell in sheet[1]:
+            cell.font = Font(bold=True)
+        for row in sheet.iter_rows(min_row=2):
+            for cell, field in zip(row, LEAD_FIELDS):
+                if cell.value and (field in LINK_FIELDS or field == "Email"):
+                    cell.hyperlink = f"mailto:{cell.value}" if field == "Email" else cell.value
+                    cell.style = "Hyperlink"
+        for col, field in enumerate(LEAD_FIELDS, 1):
+            width = max([len(field)] + [len(str(r.get(field) or "")) for r in leads])
+            sheet.column_dimensions[get_column_letter(col)].width = min(60, width + 2)
+        sheet.freeze_panes = "A2"
+        sheet.auto_filter.ref = sheet.dimensions
+    book.save(out)
+
+# Columns stored as numbers in Parquet; everything else is text
+PARQUET_FLOATS = {"Rating", "Latitude", "Longitude"}
+PARQUET_INTS = {"Reviews", "Branches", "Email Score", "Quality"}
+
+def typed_value(field, v):
+    """A lead value as its Parquet/SQL type: a number for PARQUET_FLOATS/PARQUET_INTS, else text; None if empty."""
+    if v in ("", None):
+        return None
+    try:
+        if field in PARQUET_FLOATS:
+            return float(str(v).replace(",", "."))
+        if field in PARQUET_INTS:
+            return int(re.sub(r"\D", "", str(v)) or 0)
+    except ValueError:
+        return None
+    return str(v)
+
+def sql_filter(leads, where):
+    """Leads matching an SQL WHERE clause over every column in snake_case (email, rating, query, postal_code, ...),
+    e.g. "email != '' AND rating >= 4 AND query LIKE '%Crete%'". Text columns are '' when empty; rating, reviews
+    and coordinates are numbers (NULL when unknown). The clause may only read: anything else is refused."""
+    columns = [re.sub(r"\W+", "_", f.lower()) for f in LEAD_FIELDS]
+    numeric = PARQUET_FLOATS | PARQUET_INTS
+    db = sqlite3.connect(":memory:")
+    try:
+        db.execute(f"CREATE TABLE leads ({', '.join(columns)})")
+        db.executemany(f"INSERT INTO leads(rowid, {', '.join(columns)}) VALUES (?{', ?' * len(columns)})",
+                       [(i, *(typed_value(f, r.get(f)) if f in numeric else str(r.get(f) or "") for f in LEAD_FIELDS))
+                        for i, r in enumerate(leads)])
+        allowed = {sqlite3.SQLITE_SELECT, sqlite3.SQLITE_READ, sqlite3.SQLITE_FUNCTION}
+        db.set_authorizer(lambda action, *_: sqlite3.SQLITE_OK if action in allowed else sqlite3.SQLITE_DENY)
+        keep = {i for (i,) in db.execute(f"SELECT rowid FROM leads WHERE {where}")}
+        return [r for i, r in enumerate(leads) if i in keep]
+    finally:
+        db.close()
+
+def write_parquet(out, rows):
+    """Leads as a Parquet file (a path or a binary file) for DuckDB, Spark or pandas; ratings, review counts and
+    coordinates are typed numbers, empty values are nulls."""
+    import pyarrow as pa
+    import pyarrow.parquet as pq
+    types = {f: pa.float64() if f in PARQUET_FLOATS else pa.int64() if f in PARQUET_INTS else pa.string()
+             for f in LEAD_FIELDS}
+    table = pa.table({f: pa.array([typed_value(f, r.get(f)) for r in rows], type=types[f]) for f in LEAD_FIELDS})
+    pq.write_table(table, out, compression="zstd")
+
+def query_pairs(cfg):
+    """(term, location) pairs: the --queries list when given, else every search term in every location,
+    with terms translated through localized_terms."""
+    if cfg.get("queries"):
+        pairs = [tuple(s.strip() for s in (line.rsplit(",", 1) + [""])[:2]) for line in cfg["queries"]]
+    else:
+        terms = [s.strip() for s in cfg["search_terms"].split(",") if s.strip()]
+        locations = [loc.strip() for loc in cfg["locations"].split(",") if loc.strip()] + list(cfg["areas"] or {})
+        pairs = [(t, loc) for t in terms for loc in locations]
+    return [(localize_term(cfg, t, loc), loc) for t, loc in pairs]
+
+def maps_search_url(cfg, term, location):
+    """Google Maps search URL. A location named in areas becomes an @lat,lng,zoom viewport sized to its radius;
+    with a zoom from maps_zoom_overrides (keyed by query or location) or maps_zoom, other locations are
+    geocoded to get a viewport. Lower zoom covers more ground with sparser results."""
+    query = f"{term} {location}".strip()
+    overrides = cfg["maps_zoom_overrides"] or {}
+    zoom = int(overrides.get(query) or overrides.get(location) or 0)
+    area = (cfg["areas"] or {}).get(location)
+    if area:
+        lat, lng, radius = float(area["lat"]), float(area["lng"]), float(area.get("radius_km") or 5)
+        # ~156,543 km of map span a 1,000 px wide window at zoom 0, halving with every zoom level
+        zoom = zoom or min(21, max(3, int(math.log2(156543 * math.cos(math.radians(lat)) / (2 * radius)))))
+        return f"https://www.google.com/maps/search/{quote_plus(term)}/@{lat},{lng},{zoom}z"
+    zoom = zoom or int(cfg["maps_zoom"] or 0)
+    if zoom and location:
+        lat, lng = location_coords(cfg, location)
+        if lat:
+            return f"https://www.google.com/maps/search/{quote_plus(query)}/@{lat},{lng},{zoom}z"
+    return f"https://www.google.com/maps/search/{query.replace(' ', '+')}"
+
+LOCATION_COORDS = {}  # location -> (lat, lng), so each is geocoded once per process
+
+def location_coords(cfg, location):
+    if location not in LOCATION_COORDS:
+        try:
+            LOCATION_COORDS[location] = geocode(cfg, location)
+        except Exception as e:
+            log.info(f"Geocoding {location} failed ({e}); searching without a zoom level")
+            return "", ""
+    return LOCATION_COORDS[location]
+
+def localize_term(cfg, term, location):
+    """The term as searched in location: localized_terms maps a location (or "*" for all) to
+    {term: translation}, e.g. {"*": {"law firm": "δικηγορικό γραφείο"}}. Keys match ignoring case."""
+    table = {fold(k): v for k, v in (cfg["localized_terms"] or {}).items()}
+    for scope in (fold(location), "*"):
+        match = {fold(k): v for k, v in table.get(scope, {}).items()}.get(fold(term))
+        if match:
+            return match
+    return term
+
+def build_queries(cfg):
+    return list(dict.fromkeys(f"{t} {loc}".strip() for t, loc in query_pairs(cfg)))
+
+def read_queries(path):
+    """"term, location" lines from a file or stdin ("-"); blank lines and # comments are skipped."""
+    f = sys.stdin if path == "-" else open(path, "r", encoding="utf-8")
+    with f:
+        return [line.strip() for line in f if line.strip() and not line.lstrip().startswith("#")]
+
+def split_query(q, cfg):
+    """(term, location) of a query built by build_queries()."""
+    for term, loc in query_pairs(cfg):
+        if q == f"{term} {loc}".strip():
+            return term, loc
+    for loc in sorted((s.strip() for s in cfg["locations"].split(",") if s.strip()), key=len, reverse=True):
+        if q.endswith(f" {loc}"):
+            return q[:-len(loc) - 1], loc
+    term, _, loc = q.rpartition(" ")
+    return term, loc
+
+def parse_age(text):
+    """"90d", "12h", "2w" -> timedelta."""
+    m = re.fullmatch(r"(\d+)([smhdw])", text.strip().lower())
+    if not m:
+        raise argparse.ArgumentTypeError(f"expected e.g. 90d, 12h or 2w, got {text!r}")
+    unit = {"s": "seconds", "m": "minutes", "h": "hours", "d": "days", "w": "weeks"}[m.group(2)]
+    return timedelta(**{unit: int(m.group(1))})
+
+GREEK_REGIONS = {
+    "Attica": ["Athens", "Piraeus", "Peristeri", "Kallithea", "Glyfada", "Marousi", "Kifisia"],
+    "Central Macedonia": ["Thessaloniki", "Serres", "Katerini", "Veria", "Kilkis", "Giannitsa"],
+    "Eastern Macedonia and Thrace": ["Kavala", "Alexandroupoli", "Komotini", "Xanthi", "Drama"],
+    "Western Macedonia": ["Kozani", "Ptolemaida", "Florina", "Kastoria", "Grevena"],
+    "Epirus": ["Ioannina", "Arta", "Preveza", "Igoumenitsa"],
+    "Thessaly": ["Larissa", "Volos", "Trikala", "Karditsa"],
+    "Central Greece": ["Lamia", "Chalkida", "Livadeia", "Thiva", "Amfissa"],
+    "Western Greece": ["Patras", "Agrinio", "Pyrgos", "Messolonghi"],
+    "Peloponnese": ["Kalamata", "Tripoli", "Corinth", "Argos", "Nafplio", "Sparti"],
+    "Ionian Islands": ["Corfu", "Zakynthos", "Argostoli", "Lefkada"],
+    "North Aegean": ["Mytilene", "Chios", "Samos", "Lemnos"],
+    "South Aegean": ["Rhodes", "Kos", "Syros", "Mykonos", "Santorini", "Naxos", "Paros"],
+    "Crete": ["Heraklion", "Chania", "Rethymno", "Agios Nikolaos", "Ierapetra", "Sitia"],
+}
+
+def ask(prompt, default="", check=None):
+    """Prompts until check(answer) returns no error; an empty answer takes the default."""
+    while True:
+        answer = input(f"{prompt} [{default}]: " if default != "" else f"{prompt}: ").strip() or str(default)
+        error = check(answer) if check else None
+        if not error:
+            return answer
+        print(f"  {error}")
+
+def pick_numbers(answer, n):
+    """Error message unless answer is comma-separated numbers from 1 to n."""
+    if not all(p.strip().isdigit() and 1 <= int(p) <= n for p in answer.split(",")):
+        return f"Enter numbers from 1 to {n}, separated by commas."
+
+def pick_greek_locations():
+    """Region picker, then 'all' or chosen cities within each picked region."""
+    regions = list(GREEK_REGIONS)
+    for i, name in enumerate(regions, 1):
+        print(f"  {i:2}. {name}")
+    locations = []
+    for i in ask("Regions (e.g. 1,13)", check=lambda a: pick_numbers(a, len(regions))).split(","):
+        region = regions[int(i) - 1]
+        cities = GREEK_REGIONS[region]
+        print(f"{region}: " + ", ".join(f"{j}. {c}" for j, c in enumerate(cities, 1)))
+        answer = ask("Cities", "all", lambda a: None if a == "all" else pick_numbers(a, len(cities)))
+        locations += cities if answer == "all" else [cities[int(j) - 1] for j in answer.split(",")]
+    return list(dict.fromkeys(locations))
+
+def init_wizard(path):
+    """Asks for the essentials and writes a config file that load_cfg() accepts."""
+    if path.suffix not in (".json", ".yaml", ".yml"):
+        sys.exit("init writes .json or .yaml files.")
+    if path.exists() and ask(f"{path.name} exists. Overwrite? (y/n)", "n").lower() != "y":
+        return
+    cfg = dict(DEFAULT_CFG)
+    cfg["search_terms"] = ask("Search terms, comma-separated (e.g. Dentist, Plumbers)", DEFAULT_CFG["search_terms"])
+    cfg["default_country_code"] = ask("Country calling code (30 = Greece)", DEFAULT_CFG["default_country_code"],
+                                      lambda a: None if a.isdigit() else "Digits only, e.g. 30 or 44.")
+    if cfg["default_country_code"] == "30" and ask("Pick Greek regions/cities from a list? (y/n)", "y").lower() == "y":
+        cfg["locations"] = ", ".join(pick_greek_locations())
+    else:
+        cfg["locations"] = ask("Locations, comma-separated", DEFAULT_CFG["locations"],
+                               lambda a: None if a.strip(", ") else "Enter at least one location.")
+    cfg["max_results"] = int(ask("Max results per query (0 = unlimited)", DEFAULT_CFG["max_results"],
+                                 lambda a: None if a.isdigit() else "Enter a whole number."))
+    cfg["max_pages_per_website"] = int(ask("Pages to open per website", DEFAULT_CFG["max_pages_per_website"],
+                                           lambda a: None if a.isdigit() and int(a) > 0 else "Enter 1 or more."))
+    cfg["database_path"] = ask("Leads CSV ({date} and {search_term} allowed)", DEFAULT_CFG["database_path"],
+                               lambda a: None if a.endswith(".csv") else "Use a .csv file name.")
+    cfg["headless"] = ask("Hide the browser window? (y/n)", "y").lower() == "y"
+    if path.suffix == ".json":
+        path.write_text(json.dumps(cfg, indent=2, ensure_ascii=False), encoding="utf-8")
+    else:
+        import yaml
+        path.write_text(yaml.safe_dump(cfg, allow_unicode=True, sort_keys=False), encoding="utf-8")
+    read_cfg_file(path)  # fail now rather than on the first run if it can't be read back
+    print(f"Wrote {path} ({len(build_queries(cfg))} queries). Start with: python3 main.py scrape")
+
+def read_cfg_file(path):
+    """Settings from a .json, .yaml/.yml or .toml file, by extension."""
+    text = path.read_text(encoding="utf-8")
+    if path.suffix in (".yaml", ".yml"):
+        import yaml
+        return yaml.safe_load(text) or {}
+    if path.suffix == ".toml":
+        try:
+            import tomllib
+        except ImportError:  # Python < 3.11
+            import tomli as tomllib
+        return tomllib.loads(text)
+    return json.loads(text)
 
 def load_cfg():
+    cfg = dict(DEFAULT_CFG)
     if CFG_FILE.exists():
-        return json.loads(CFG_FILE.read_text())
-    return DEFAULT_CFG
+        cfg.update(read_cfg_file(CFG_FILE))
+    # A named profile's settings (search terms, locations, database_path, ...) win over the shared ones
+    cfg.update(cfg["profiles"].get(CFG_OVERRIDES.get("profile", cfg["profile"]), {}))
+    return {**cfg, **CFG_OVERRIDES}
+
+engine = Engine()
+app = Flask(__name__)
 
 @app.route("/")
 def index():
@@ -213,34 +3097,863 @@ def status():
     return jsonify({
         "running": engine.active, 
         "leads": engine.data, 
+        "failures": len(engine.errors),
+        "degraded": engine.degraded,
         "logs": log_handler.buffer, 
-        "config": load_cfg()
+        # the settings form edits the file itself, not load_cfg() with the profile and --db/--queries merged in
+        "config": redact(read_cfg_file(CFG_FILE) if CFG_FILE.exists() else {})
     })
 
+@app.route("/api/progress")
+def progress():
+    """Server-Sent Events: a fresh Engine.progress() snapshot whenever it changes."""
+    def events():
+        last = None
+        while True:
+            snapshot = engine.progress()
+            if snapshot != last:
+                yield f"data: {json.dumps(snapshot, ensure_ascii=False)}\n\n"
+                last = snapshot
+            time.sleep(1)
+    return Response(stream_with_context(events()), mimetype="text/event-stream", headers={"Cache-Control": "no-cache"})
+
+@app.route("/api/runs")
+def runs():
+    return jsonify(engine.runs)
+
+def start_job(cfg, job=None):
+    if engine.active:
+        return False
+    engine.active = True
+    job = job or engine.run
+    threading.Thread(target=lambda: asyncio.run(job(cfg))).start()
+    return True
+
+def number_arg(name, default, kind=int):
+    """Query parameter ?name= as a number (default when absent); ValueError names the bad parameter."""
+    value = request.args.get(name, "")
+    try:
+        return kind(value) if value else default
+    except ValueError:
+        raise ValueError(f"?{name}= must be a number, not {value!r}") from None
+
+@app.route("/api/search")
+def api_search():
+    """Leads matching ?q= (every word, accents and case ignored), best first; ?limit= caps them (default 20)."""
+    try:
+        limit = number_arg("limit", 20)
+    except ValueError as e:
+        return jsonify({"error": str(e)}), 400
+    return jsonify(search_leads(engine.data, request.args.get("q", ""), limit))
+
 @app.route("/control/<action>", methods=["POST"])
 def control(action):
-    if action == "start" and not engine.active:
-        threading.Thread(target=lambda: asyncio.run(engine.run(load_cfg()))).start()
+    if action == "start":
+        start_job(load_cfg())
+    elif action == "retry":
+        start_job(load_cfg(), engine.retry_failed)
     elif action == "stop":
         engine.active = False
     elif action == "clear":
-        engine.data = []
-        if DB_FILE.exists():
-            DB_FILE.unlink()
+        engine.clear()
         log.info("Results cleared.")
     return jsonify({"success": True})
 
+@app.route("/api/queries", methods=["GET", "POST", "DELETE"])
+def queries():
+    """Adds ({"term", "location"} or {"query"}) or cancels ({"query"}, DELETE) searches of the running job."""
+    if request.method != "GET":
+        body = request.json or {}
+        term, location = body.get("term", "").strip(), body.get("location", "").strip()
+        if term:
+            term = localize_term(engine.cfg, term, location)
+        q = body.get("query") or f"{term} {location}".strip()
+        if not q:
+            return jsonify({"error": "Give a query, or a term and location."}), 400
+        if request.method == "POST":
+            if not engine.active or not engine.runs or engine.runs[-1]["mode"] != "maps":
+                return jsonify({"error": "No search run in progress; start one with these queries instead."}), 409
+            if q != engine.current_query and q not in engine.queue:
+                if term and not body.get("query"):
+                    engine.added_queries[q] = (term, location)
+                engine.queue.append(q)
+                engine.runs[-1]["queries"].append(q)
+                log.info(f"Query added: {q}")
+        elif q in engine.queue:
+            engine.queue.remove(q)
+            log.info(f"Query cancelled: {q}")
+        else:
+            return jsonify({"error": f"{q!r} is not pending."}), 404
+    return jsonify({"current": engine.current_query, "pending": engine.queue})
+
 @app.route("/config", methods=["POST"])
 def save_config():
-    CFG_FILE.write_text(json.dumps(request.json))
+    if CFG_FILE.suffix != ".json":
+        return jsonify({"error": f"Settings come from {CFG_FILE.name}; edit that file instead."}), 409
+    stored = read_cfg_file(CFG_FILE) if CFG_FILE.exists() else {}
+    # the dashboard only ever sees redacted credentials; a "***" sent back means "keep the saved one"
+    cfg = {k: stored[k] if v == "***" else v for k, v in request.json.items() if v != "***" or k in stored}
+    CFG_FILE.write_text(json.dumps(cfg))
+    if not engine.active:
+        engine.open_db(load_cfg())
     return jsonify({"success": True})
 
 @app.route("/download")
 def download():
-    return send_file(DB_FILE, as_attachment=True)
+    """Leads file; ?postal_code= (prefix, e.g. 546) and ?city= narrow it down, ?collapse_chains=1 keeps one
+    row per chain, ?preset=google|outlook switches to that contacts-import layout and ?sort=quality puts the
+    best leads first."""
+    postal, city = request.args.get("postal_code", "").replace(" ", ""), fold(request.args.get("city", "").strip())
+    near = request.args.get("near", "")
+    try:
+        radius = number_arg("radius_km", 0.0, float)
+        lat, lng = (float(v) for v in near.split(",")) if near else (0.0, 0.0)
+    except ValueError as e:
+        return jsonify({"error": str(e) if "?" in str(e) else f"?near= must be lat,lng, not {near!r}"}), 400
+    collapse = request.args.get("collapse_chains", str(engine.cfg["collapse_chains"])).lower() in ("1", "true")
+    preset, by_quality = request.args.get("preset", ""), request.args.get("sort") == "quality"
+    if preset and preset not in CONTACT_PRESETS:
+        return jsonify({"error": f"Unknown preset {preset} (choose from {', '.join(CONTACT_PRESETS)})"}), 400
+    if not (postal or city or (near and radius) or collapse or preset or by_quality or engine.suppressed):
+        return send_file(engine.db_file, as_attachment=True)
+    rows = [r for r in engine.suppress(engine.data) if r.get("Postal Code", "").startswith(postal)
+            and (not city or fold(r.get("City", "")) == city)]
+    if near and radius:
+        rows = [r for r in rows if r.get("Latitude") and
+                distance_km(lat, lng, float(r["Latitude"]), float(r["Longitude"])) <= radius]
+    if collapse:
+        rows = collapse_chains(rows)
+    if by_quality:
+        rows = sorted(rows, key=lambda r: -quality_score(engine.cfg, r))
+    fields = LEAD_FIELDS
+    if preset:
+        fields, convert = CONTACT_PRESETS[preset]
+        rows = [convert(r) for r in rows]
+    buf = io.StringIO()
+    w = csv.DictWriter(buf, fieldnames=fields, extrasaction="ignore")
+    w.writeheader()
+    w.writerows(rows)
+    return send_file(io.BytesIO(buf.getvalue().encode("utf-8")), mimetype="text/csv", as_attachment=True,
+                     download_name=engine.db_file.name)
+
+@app.route("/download/xlsx")
+def download_xlsx():
+    """Leads as an Excel workbook, one sheet per search query."""
+    buf = io.BytesIO()
+    write_xlsx(buf, engine.suppress(engine.data))
+    buf.seek(0)
+    return send_file(buf, as_attachment=True, download_name=f"{engine.db_file.stem}.xlsx",
+                     mimetype="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+
+@app.route("/download/parquet")
+def download_parquet():
+    """Leads as Parquet, for DuckDB/Spark."""
+    buf = io.BytesIO()
+    write_parquet(buf, engine.suppress(engine.data))
+    buf.seek(0)
+    return send_file(buf, as_attachment=True, download_name=f"{engine.db_file.stem}.parquet",
+                     mimetype="application/vnd.apache.parquet")
+
+@app.route("/download/geojson")
+def download_geojson():
+    """Leads with coordinates as a GeoJSON FeatureCollection, for QGIS, uMap, geojson.io, ..."""
+    features = [{"type": "Feature", "properties": {k: v for k, v in r.items() if k not in ("Latitude", "Longitude")},
+                 "geometry": {"type": "Point", "coordinates": [float(r["Longitude"]), float(r["Latitude"])]}}
+                for r in engine.suppress(engine.data) if r.get("Latitude") and r.get("Longitude")]
+    body = json.dumps({"type": "FeatureCollection", "features": features}, ensure_ascii=False)
+    return send_file(io.BytesIO(body.encode("utf-8")), mimetype="application/geo+json", as_attachment=True,
+                     download_name=f"{engine.db_file.stem}.geojson")
+
+@app.route("/download/emails")
+def download_emails():
+    """Every address found, minus those on the suppression list."""
+    if not engine.suppressed:
+        return send_file(engine.emails_file, as_attachment=True)
+    blocked = {s["Email"] for s in engine.suppressed}
+    buf = io.StringIO()
+    w = csv.DictWriter(buf, fieldnames=EMAIL_FIELDS, extrasaction="ignore")
+    w.writeheader()
+    w.writerows(e for e in engine.emails if e["Email"].lower() not in blocked)
+    return send_file(io.BytesIO(buf.getvalue().encode("utf-8")), mimetype="text/csv", as_attachment=True,
+                     download_name=engine.emails_file.name)
+
+@app.route("/download/errors")
+def download_errors():
+    return send_file(engine.errors_file, as_attachment=True)
+
+@app.route("/download/phones")
+def download_phones():
+    return send_file(engine.phones_file, as_attachment=True)
+
+@app.route("/download/socials")
+def download_socials():
+    return send_file(engine.socials_file, as_attachment=True)
+
+# --- gRPC API ---
+def serve_grpc(port):
+    """Serves the control API from proto/scraper.proto (generated with grpc_tools.protoc)."""
+    from concurrent import futures
+    import grpc
+    import scraper_pb2 as pb
+    import scraper_pb2_grpc as pb_grpc
+
+    lead_fields = {"company": "Company", "email": "Email", "phone": "Phone", "website": "Website",
+                   "category": "Category", "address": "Address", "rating": "Rating",
+                   "reviews": "Reviews", "maps_url": "Maps URL"}
+
+    class Servicer(pb_grpc.ScraperServicer):
+        def StartJob(self, req, ctx):
+            cfg = load_cfg()
+            overrides = {"search_terms": req.search_terms, "locations": req.locations, "max_results": req.max_results}
+            cfg = {**cfg, **{k: v for k, v in overrides.items() if v}}
+            if not start_job(cfg):
+                return pb.JobReply(accepted=False, message="A job is already running.")
+            return pb.JobReply(accepted=True, message=f"Started {len(build_queries(cfg))} queries.")
+
+        def CancelJob(self, req, ctx):
+            was_running, engine.active = engine.active, False
+            return pb.JobReply(accepted=was_running, message="Stopping." if was_running else "No job running.")
+
+        def StreamProgress(self, req, ctx):
+            last = None
+            while ctx.is_active():
+                msg = pb.Progress(running=engine.active, leads=len(engine.data),
+                                  message=log_handler.buffer[-1] if log_handler.buffer else "")
+                if msg != last:
+                    yield msg
+                    last = msg
+                if not engine.active and last is not None:
+                    return
+                time.sleep(1)
+
+        def ListLeads(self, req, ctx):
+            rows = engine.suppress(engine.data)[req.offset:]
+            if req.limit:
+                rows = rows[:req.limit]
+            leads = [pb.Lead(**{k: r.get(col) or "" for k, col in lead_fields.items()}) for r in rows]
+            return pb.LeadList(leads=leads, total=len(engine.data))
+
+    server = grpc.server(futures.ThreadPoolExecutor(max_workers=8))
+    pb_grpc.add_ScraperServicer_to_server(Servicer(), server)
+    server.add_insecure_port(f"[::]:{port}")
+    server.start()
+    log.info(f"gRPC API listening on :{port}")
+    return server
+
+# --- OUTREACH ---
+def lead_vars(lead):
+    """Template variables: every column as-is (lead["Maps URL"]) plus snake_case names (maps_url)."""
+    return {"lead": lead, **{re.sub(r"\W+", "_", k.lower()): v for k, v in lead.items() if k}}
+
+def mail_merge(template_path, leads, out_dir=None):
+    """Renders each lead through a Jinja2 template; one file per lead, or a combined preview string."""
+    from jinja2 import Template
+    tpl = Template(Path(template_path).read_text(encoding="utf-8"))
+    rendered = [(lead, tpl.render(**lead_vars(lead))) for lead in leads]
+    if not out_dir:
+        return "\n\n----------\n\n".join(text for _, text in rendered)
+    out = Path(out_dir)
+    out.mkdir(parents=True, exist_ok=True)
+    for i, (lead, text) in enumerate(rendered, 1):
+        slug = re.sub(r"\W+", "-", (lead.get("Company") or "lead").lower()).strip("-")[:40]
+        (out / f"{i:04d}-{slug}.txt").write_text(text, encoding="utf-8")
+    return f"Wrote {len(rendered)} files to {out}"
+
+def send_campaign(cfg, db, template_path, limit=0, confirm=False):
+    """Emails a rendered template to every lead not in the sent log, throttled to send_per_hour. Addresses the
+    verifier called invalid or risky are skipped; with an email_verifier set, only verified ("valid") ones go.
+
+    The template's first line must be "Subject: ...". Without confirm it only lists the recipients.
+    """
+    from jinja2 import Template
+    subject_line, _, body = Path(template_path).read_text(encoding="utf-8").partition("\n")
+    if not subject_line.lower().startswith("subject:"):
+        raise ValueError("The template must start with a 'Subject: ...' line.")
+    subject_tpl, body_tpl = Template(subject_line[8:].strip()), Template(body.lstrip("\n"))
+
+    if confirm and cfg["suppression_provider"]:
+        db.sync_suppressions(cfg)
+    sent = {s["Email"] for s in db.sent if s["Status"] == "sent"}
+    leads = [r for r in db.suppress(db.data) if r.get("Email") and r["Email"] not in sent
+             and email_digest(r["Email"]) not in sent
+             and (r.get("Email Status") == "valid" if cfg["email_verifier"]
+                  else r.get("Email Status") not in ("invalid", "risky"))]
+    leads = leads[:limit] if limit else leads
+    if not confirm:
+        for r in leads:
+            print(f"{r['Email']:<40} {r.get('Company', '')}")
+        print(f"{len(leads)} recipients. Re-run with --confirm to send.")
+        return
+
+    delay = 3600 / max(1, int(cfg["send_per_hour"]))
+    smtp = smtplib.SMTP(cfg["smtp_host"], int(cfg["smtp_port"]), timeout=30)
+    try:
+        if cfg["smtp_starttls"]:
+            smtp.starttls()
+        if cfg["smtp_user"]:
+            smtp.login(cfg["smtp_user"], cfg["smtp_password"])
+        for i, r in enumerate(leads):
+            variables = {**lead_vars(r), "unsubscribe_url": cfg["unsubscribe_url"]}
+            msg = EmailMessage()
+            msg["From"], msg["To"] = cfg["smtp_from"] or cfg["smtp_user"], r["Email"]
+            msg["Subject"] = subject_tpl.render(**variables)
+            unsubscribe = ([f"<mailto:{cfg['unsubscribe_email']}?subject=unsubscribe>"]
+                           if cfg["unsubscribe_email"] else [])
+            if cfg["unsubscribe_url"]:
+                unsubscribe.append(f"<{cfg['unsubscribe_url']}>")
+                msg["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+            if unsubscribe:
+                msg["List-Unsubscribe"] = ", ".join(unsubscribe)
+            msg.set_content(body_tpl.render(**variables))
+            entry = {"Email": r["Email"], "Lead": lead_key(r), "Subject": msg["Subject"],
+                     "Sent At": datetime.now().isoformat(timespec="seconds")}
+            try:
+                smtp.send_message(msg)
+                db.sent.append({**entry, "Status": "sent", "Error": ""})
+                log.info(f"Sent {i + 1}/{len(leads)} to {r['Email']}")
+            except smtplib.SMTPException as e:
+                db.sent.append({**entry, "Status": "failed", "Error": str(e)[:300]})
+                log.info(f"Send to {r['Email']} failed: {e}")
+            db.save()
+            if i + 1 < len(leads):
+                time.sleep(delay)
+    finally:
+        smtp.quit()
+
+# --- INTEGRATIONS ---
+def notify(cfg, title, message, env):
+    """Desktop notification and/or user command hook (run details passed as SCRAPER_* env vars)."""
+    if sys.platform == "darwin":
+        desktop = ["osascript", "-e", f"display notification {json.dumps(message)} with title {json.dumps(title)}"]
+    elif sys.platform == "win32":
+        desktop = ["msg", "*", f"{title}: {message}"]
+    else:
+        desktop = ["notify-send", title, message]
+    for enabled, cmd, kwargs in [(cfg.get("notify_desktop"), desktop, {}),
+                                 (cfg.get("notify_command"), cfg.get("notify_command"),
+                                  {"shell": True, "env": {**os.environ, **env}})]:
+        if enabled:
+            try:
+                subprocess.run(cmd, timeout=60, **kwargs)
+            except Exception as e:
+                log.info(f"Notification failed: {e}")
+
+def http_json(method, url, body=None, headers=None):
+    data = json.dumps(body).encode() if body is not None else None
+    req = urllib.request.Request(url, data=data, method=method,
+                                 headers={"Content-Type": "application/json", **(headers or {})})
+    with urllib.request.urlopen(req, timeout=30) as resp:
+        return json.loads(resp.read() or b"null")
+
+def push_airtable(cfg, leads):
+    """Upserts leads into Airtable, merging on the (mapped) Website field."""
+    fmap = {col: col for col in LEAD_FIELDS} | cfg.get("airtable_field_map", {})
+    url = f"https://api.airtable.com/v0/{cfg['airtable_base_id']}/{quote(cfg['airtable_table'])}"
+    headers = {"Authorization": f"Bearer {cfg['airtable_api_key']}"}
+    records = [{"fields": {fmap[c]: r[c] for c in LEAD_FIELDS if fmap.get(c) and r.get(c)}}
+               for r in leads if r.get("Website")]
+    for i in range(0, len(records), 10):
+        http_json("PATCH", url, {"performUpsert": {"fieldsToMergeOn": [fmap["Website"]]},
+                                 "records": records[i:i + 10], "typecast": True}, headers)
+    log.info(f"Synced {len(records)} leads to Airtable ({len(leads) - len(records)} without a website skipped).")
+
+def push_webhook(cfg, leads, url=""):
+    """POSTs leads as JSON batches ({"leads": [...], "batch": n, "batches": total}) to url (default webhook_url),
+    for Zapier, Make or n8n. With webhook_secret every body is signed: X-Signature-256: sha256=<HMAC of the body>.
+    Network errors, 429 and 5xx are retried webhook_retries times with backoff; a batch that still fails stops
+    the push."""
+    url, size = url or cfg["webhook_url"], max(1, int(cfg["webhook_batch_size"]))
+    batches = [leads[i:i + size] for i in range(0, len(leads), size)]
+    for n, batch in enumerate(batches, 1):
+        body = json.dumps({"leads": [{f: r.get(f, "") for f in LEAD_FIELDS} for r in batch], "batch": n,
+                           "batches": len(batches)}, ensure_ascii=False).encode()
+        headers = {"Content-Type": "application/json", "User-Agent": "maps-lead-scraper"}
+        if cfg["webhook_secret"]:
+            digest = hmac.new(cfg["webhook_secret"].encode(), body, hashlib.sha256).hexdigest()
+            headers["X-Signature-256"] = f"sha256={digest}"
+        for attempt in range(int(cfg["webhook_retries"]) + 1):
+            try:
+                with urllib.request.urlopen(urllib.request.Request(url, body, headers, method="POST"), timeout=30):
+                    break
+            except (urllib.error.URLError, TimeoutError) as e:
+                code = getattr(e, "code", 0)
+                if attempt == int(cfg["webhook_retries"]) or (code and code != 429 and code < 500):
+                    raise RuntimeError(f"batch {n}/{len(batches)} failed: {e}") from e
+                time.sleep(2 ** attempt)
+    log.info(f"Pushed {len(leads)} leads to {urlparse(url).netloc} in {len(batches)} batches.")
+
+# --- EMAIL VERIFICATION ---
+# Each adapter takes (cfg, emails) and returns {email: (status, score)} with status one of
+# valid / invalid / catch-all / risky / unknown and score 0-100 where the provider gives one
+def zerobounce_verify(cfg, emails):
+    statuses = {"valid": "valid", "invalid": "invalid", "catch-all": "catch-all", "spamtrap": "risky",
+                "abuse": "risky", "do_not_mail": "risky"}
+    reply = http_json("POST", "https://bulkapi.zerobounce.net/v2/validatebatch",
+                      {"api_key": cfg["email_verifier_api_key"],
+                       "email_batch": [{"email_address": e} for e in emails]})
+    return {r["address"].lower(): (statuses.get(r["status"], "unknown"), "") for r in reply["email_batch"]}
+
+def neverbounce_verify(cfg, emails):
+    statuses = {"valid": "valid", "invalid": "invalid", "catchall": "catch-all", "disposable": "risky"}
+    found = {}
+    for email in emails:  # the bulk API is asynchronous jobs; single checks keep this synchronous
+        reply = http_json("GET", f"https://api.neverbounce.com/v4/single/check?key="
+                                 f"{quote(cfg['email_verifier_api_key'])}&email={quote(email)}")
+        found[email] = (statuses.get(reply.get("result"), "unknown"), "")
+    return found
+
+def hunter_verify(cfg, emails):
+    statuses = {"valid": "valid", "webmail": "valid", "invalid": "invalid", "accept_all": "catch-all",
+                "disposable": "risky"}
+    found = {}
+    for email in emails:
+        data = http_json("GET", f"https://api.hunter.io/v2/email-verifier?email={quote(email)}"
+                                f"&api_key={quote(cfg['email_verifier_api_key'])}")["data"]
+        found[email] = (statuses.get(data.get("status"), "unknown"), str(data.get("score") or ""))
+    return found
+
+DNS_CACHE = {}  # (name, record type) -> (expiry, answers), shared by the verification workers
+DNS_LOCK = threading.Lock()
+
+@functools.lru_cache(maxsize=None)
+def dns_resolver(nameservers):
+    """dnspython resolver for the comma-separated dns_resolver addresses, or the system's when empty."""
+    import dns.resolver
+    resolver = dns.resolver.Resolver(configure=not nameservers)
+    if nameservers:
+        resolver.nameservers = [ns.strip() for ns in nameservers.split(",") if ns.strip()]
+    return resolver
+
+def dns_lookup(cfg, name, rtype):
+    """Records of one type, cached for their TTL (a missing name or record is cached for 5 minutes)."""
+    import dns.resolver
+    with DNS_LOCK:
+        hit = DNS_CACHE.get((name, rtype))
+    if hit and hit[0] > time.time():
+        return hit[1]
+    try:
+        answer = dns_resolver(cfg["dns_resolver"]).resolve(name, rtype)
+        found, ttl = list(answer), answer.rrset.ttl
+    except (dns.resolver.NoAnswer, dns.resolver.NXDOMAIN):
+        found, ttl = [], 300
+    with DNS_LOCK:
+        DNS_CACHE[(name, rtype)] = (time.time() + ttl, found)
+    return found
+
+def mx_hosts(cfg, domain):
+    """Mail servers of a domain, lowest preference first; the domain itself when it has no MX but has an
+    address (RFC 5321), and none when it has neither."""
+    mxs = sorted(dns_lookup(cfg, domain, "MX"), key=lambda mx: mx.preference)
+    if mxs:
+        return [str(mx.exchange).rstrip(".") for mx in mxs]
+    return [domain] if dns_lookup(cfg, domain, "A") else []
+
+def smtp_verify(cfg, emails):
+    """Asks each domain's mail server whether it would accept the addresses (RCPT TO, no message is sent).
+    A server that also accepts a random mailbox is catch-all: it says yes to everything, so its addresses
+    are reported risky instead of valid."""
+    helo = cfg["smtp_verify_helo"] or socket.getfqdn()
+    sender = cfg["smtp_verify_from"] or f"verify@{helo}"
+    by_domain = {}
+    for email in emails:
+        by_domain.setdefault(email.rpartition("@")[2], []).append(email)
+    found = {}
+
+    def check(domain, addrs):
+        try:
+            hosts = mx_hosts(cfg, domain)
+            if not hosts:
+                found.update({email: ("invalid", "") for email in addrs})  # the domain cannot receive mail
+                return
+            with smtplib.SMTP(hosts[0], 25, timeout=float(cfg["smtp_verify_timeout_sec"])) as smtp:
+                smtp.ehlo(helo)
+                smtp.mail(sender)
+                catch_all = smtp.rcpt(f"{secrets.token_hex(8)}@{domain}")[0] in (250, 251)
+                for email in addrs:
+                    code = smtp.rcpt(email)[0]
+                    if code in (250, 251):
+                        found[email] = ("risky" if catch_all else "valid", "")
+                    else:  # 4xx is greylisting or a busy server: ask again another day
+                        found[email] = ("invalid" if 500 <= code < 600 else "unknown", "")
+        except Exception as e:
+            log.info(f"SMTP check of {domain} failed: {type(e).__name__}")
+            found.update({email: ("unknown", "") for email in addrs})
+
+    with ThreadPoolExecutor(max_workers=max(1, int(cfg["smtp_verify_workers"]))) as pool:
+        list(pool.map(check, by_domain, by_domain.values()))
+    return found
+
+VERIFIERS = {"zerobounce": zerobounce_verify, "neverbounce": neverbounce_verify, "hunter": hunter_verify,
+             "smtp": smtp_verify}
+
+# --- SUPPRESSION LISTS ---
+# Each returns {email: reason} for every address the email service says must not be contacted
+def mailchimp_suppressed(cfg):
+    key = cfg["mailchimp_api_key"]
+    auth = base64.b64encode(f"anystring:{key}".encode()).decode()
+    base = f"https://{key.rsplit('-', 1)[-1]}.api.mailchimp.com/3.0/lists/{cfg['mailchimp_list_id']}/members"
+    found = {}
+    for status, reason in (("unsubscribed", "unsubscribed"), ("cleaned", "bounced")):
+        offset = 0
+        while True:
+            page = http_json("GET", f"{base}?status={status}&fields=members.email_address&count=1000&offset={offset}",
+                             headers={"Authorization": f"Basic {auth}"})["members"]
+            found.update({m["email_address"].lower(): reason for m in page})
+            offset += len(page)
+            if len(page) < 1000:
+                break
+    return found
+
+def brevo_suppressed(cfg):
+    found, offset = {}, 0
+    while True:
+        reply = http_json("GET", f"https://api.brevo.com/v3/smtp/blockedContacts?limit=100&offset={offset}",
+                          headers={"api-key": cfg["brevo_api_key"]})
+        contacts = reply.get("contacts", [])
+        found.update({c["email"].lower(): (c.get("reason") or {}).get("code", "blocked") for c in contacts})
+        offset += len(contacts)
+        if not contacts or offset >= reply.get("count", 0):
+            break
+    return found
+
+SUPPRESSION_PROVIDERS = {"mailchimp": mailchimp_suppressed, "brevo": brevo_suppressed}
+
+# --- COMPANY DATA ---
+# Each takes (cfg, lead) and returns the Legal Name / VAT Number / Employees it knows ({} if not found)
+def clearbit_company(cfg, lead):
+    if not lead.get("Domain"):
+        return {}
+    try:
+        data = http_json("GET", f"https://company.clearbit.com/v2/companies/find?domain={quote(lead['Domain'])}",
+                         headers={"Authorization": f"Bearer {cfg['company_enricher_api_key']}"})
+    except urllib.error.HTTPError as e:
+        if e.code == 404:
+            return {}
+        raise
+    return {"Legal Name": data.get("legalName") or "", "VAT Number": "",
+            "Employees": (data.get("metrics") or {}).get("employeesRange") or ""}
+
+def gemi_company(cfg, lead):
+    """Searches the Greek business registry (GEMI open data API) by name; with several hits the one in the
+    lead's city wins, else nothing, since a name alone often matches unrelated businesses."""
+    reply = http_json("GET", "https://opendata-api.businessportal.gr/api/opendata/v1/companies"
+                             f"?name={quote(lead.get('Company', ''))}&resultsSize=10",
+                      headers={"api_key": cfg["company_enricher_api_key"]})
+    hits = reply.get("searchResults") or []
+    city = fold(lead.get("City", ""))
+    matches = [h for h in hits if city and city in fold(f"{h.get('city') or ''} {h.get('municipality') or ''}")]
+    hit = matches[0] if matches else hits[0] if len(hits) == 1 else None
+    if not hit:
+        return {}
+    return {"Legal Name": hit.get("coNameEl") or hit.get("coName") or "", "VAT Number": hit.get("afm") or "",
+            "Employees": ""}  # GEMI keeps no headcount
+
+ENRICHERS = {"clearbit": clearbit_company, "gemi": gemi_company}
+
+def geocode(cfg, address):
+    """(lat, lng) strings for an address via Nominatim or the Google Geocoding API; empty strings if not found."""
+    if cfg["geocoder"] == "google":
+        url = (f"https://maps.googleapis.com/maps/api/geocode/json?address={quote(address)}"
+               f"&key={cfg['google_maps_api_key']}")
+        hits = [r["geometry"]["location"] for r in http_json("GET", url)["results"]]
+        return (str(hits[0]["lat"]), str(hits[0]["lng"])) if hits else ("", "")
+    url = f"https://nominatim.openstreetmap.org/search?format=json&limit=1&q={quote(address)}"
+    hits = http_json("GET", url, headers={"User-Agent": "maps-lead-scraper"})
+    time.sleep(1)  # Nominatim usage policy: at most one request per second
+    return (hits[0]["lat"], hits[0]["lon"]) if hits else ("", "")
+
+def geocode_missing(cfg, leads):
+    """Fills Latitude/Longitude for leads with an address but no coordinates in their Maps URL."""
+    todo = [r for r in leads if r.get("Address") and not r.get("Latitude")]
+    found = 0
+    for r in todo:
+        try:
+            r["Latitude"], r["Longitude"] = geocode(cfg, r["Address"])
+            found += bool(r["Latitude"])
+        except Exception as e:
+            log.info(f"Geocoding {r['Address']} failed: {e}")
+    log.info(f"Geocoded {found}/{len(todo)} addresses with {cfg['geocoder']}.")
+
+RDAP_ROLES = ["registrant", "administrative", "technical", "abuse"]  # best first
+
+def domain_contact(domain):
+    """(email, role) for a domain from RDAP, falling back to WHOIS for TLDs without RDAP (e.g. .gr)."""
+    try:
+        found = {}
+        stack = list(http_json("GET", f"https://rdap.org/domain/{domain}").get("entities", []))
+        while stack:
+            entity = stack.pop()
+            stack += entity.get("entities", [])
+            emails = [v[3] for v in (entity.get("vcardArray") or [None, []])[1] if v[0] == "email"]
+            for role in entity.get("roles", []):
+                if emails and role in RDAP_ROLES:
+                    found.setdefault(role, emails[0].lower())
+        role = next((r for r in RDAP_ROLES if r in found), "")
+        return (found[role], role) if role else ("", "")
+    except urllib.error.HTTPError as e:
+        if e.code != 404:
+            raise
+    emails = [m.lower() for m in EMAIL_REGEX.findall(whois(domain)) if valid_email(m)]
+    return (emails[0], "whois") if emails else ("", "")
+
+def whois(domain, server="whois.iana.org"):
+    """Raw WHOIS answer, following the IANA referral to the TLD's server."""
+    with socket.create_connection((server, 43), timeout=15) as s:
+        s.sendall(f"{domain}\r\n".encode("idna"))
+        answer = b"".join(iter(lambda: s.recv(4096), b"")).decode("utf-8", "replace")
+    refer = re.search(r"^(?:refer|whois):\s*(\S+)", answer, re.MULTILINE | re.IGNORECASE)
+    return whois(domain, refer.group(1)) if refer and server == "whois.iana.org" else answer
+
+def connect_redis(url):
+    import redis
+    return redis.Redis.from_url(url)
 
 if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Maps Lead Scraper")
+    parser.add_argument("--config", type=Path, help="Settings file (.json, .yaml/.yml or .toml)")
+    parser.add_argument("--profile", help="Named profile from the config file's profiles section")
+    parser.add_argument("--db", help="Leads CSV path, supports {date} and {search_term}")
+    parser.add_argument("--log-file", default=str(LOG_FILE), help="Log file, rotated by size")
+    parser.add_argument("--log-format", choices=["text", "json"], default="text")
+    parser.add_argument("--log-max-mb", type=float, default=10, help="Rotate the log file at this size")
+    parser.add_argument("--log-backups", type=int, default=3, help="Rotated log files to keep")
+    parser.add_argument("--pprof", metavar="ADDR", help="Serve /debug/memory and /debug/stacks, e.g. :6060")
+    parser.add_argument("--cpuprofile", help="Write cProfile stats for the run to this file")
+    parser.add_argument("--memprofile", help="Write the top memory allocation sites to this file on exit")
+    parser.add_argument("--output", help="Stream every finished lead as a JSON line to this file ('-' = stdout)")
+    sub = parser.add_subparsers(dest="cmd")
+    serve = sub.add_parser("serve", help="Run the web dashboard (default)")
+    serve.add_argument("--grpc-port", type=int, default=argparse.SUPPRESS,
+                       help="Also serve the gRPC control API on this port")
+    for name, text in [("coordinator", "Queue place tasks in Redis and collect results"),
+                       ("worker", "Scrape place tasks from Redis")]:
+        p = sub.add_parser(name, help=text)
+        p.add_argument("--redis", default=os.environ.get("REDIS_URL", "redis://localhost:6379/0"))
+        p.add_argument("--proxy", default="", help="Proxy server for this process's browser")
+    init = sub.add_parser("init", help="Answer a few questions to write a config file")
+    init.add_argument("--out", type=Path, help="File to write, .json or .yaml (default: the config file in use)")
+    scrape = sub.add_parser("scrape", help="Run the configured searches once, without the dashboard")
+    for p in (scrape, sub.choices["coordinator"]):
+        p.add_argument("--queries", help="File with one 'term, location' per line ('-' = stdin) instead of the "
+                                         "configured search terms and locations")
+    sites = sub.add_parser("scrape-websites", help="Extract emails from a CSV of websites, skipping Google Maps")
+    sites.add_argument("--input", required=True, help="CSV with a website/url/domain column (or URLs in column one)")
+    sub.add_parser("airtable", help="Upsert all saved leads into the configured Airtable table")
+    push = sub.add_parser("push", help="Send leads to another service")
+    targets = push.add_subparsers(dest="target", required=True)
+    webhook = targets.add_parser("webhook", help="POST leads in signed JSON batches (Zapier, Make, n8n, ...)")
+    webhook.add_argument("--url", help="Endpoint (default: webhook_url)")
+    webhook.add_argument("--batch-size", type=int, help="Leads per request (default: webhook_batch_size)")
+    webhook.add_argument("--since-last", action="store_true", help="Only leads added since the previous push")
+    sub.add_parser("geocode", help="Resolve coordinates for saved leads that have none (needs geocoder)")
+    sub.add_parser("verify", help="Check saved lead emails with the configured email_verifier")
+    companies = sub.add_parser("enrich-companies", help="Add legal name, VAT number and headcount to saved leads "
+                                                         "from the configured company_enricher")
+    companies.add_argument("--all", action="store_true", help="Look up leads that were looked up before too")
+    sub.add_parser("runs", help="List recorded runs and what each one added")
+    stats = sub.add_parser("stats", help="Leads, email and gold-lead rates per query, top email domains, run times")
+    stats.add_argument("--format", choices=["table", "json"], default="table")
+    listing = sub.add_parser("list", help="Show saved leads, filtered and a page at a time")
+    listing.add_argument("--has-email", action="store_true", help="Only leads with an email")
+    listing.add_argument("--location", default="", help="City, address or search location containing this")
+    listing.add_argument("--category", default="", help="Category containing this")
+    listing.add_argument("--limit", type=int, default=50, help="Leads per page (default 50; 0 for all)")
+    listing.add_argument("--offset", type=int, default=0, help="Skip this many leads first (next page: +limit)")
+    listing.add_argument("--format", choices=["table", "json"], default="table")
+    search = sub.add_parser("search", help="Find saved leads by name, category, address, query, email or website")
+    search.add_argument("text", help='Words to look for, e.g. "κομμωτήριο γλυφάδα" (accents and case ignored)')
+    search.add_argument("--limit", type=int, default=20, help="Show at most this many leads (default 20)")
+    sub.add_parser("retry-failed", help="Re-attempt every search, place and website that failed before")
+    export = sub.add_parser("export", help="Write leads to a CSV, optionally only those added since the last export")
+    export.add_argument("--out", default="-", help="Output CSV; a name ending in .xlsx writes an Excel workbook, "
+                                                   ".parquet a Parquet file (default: CSV to stdout)")
+    export.add_argument("--collapse-chains", action="store_true", help="One row per chain, with its branch count")
+    export.add_argument("--sql", default="", metavar="WHERE",
+                        help="Only leads matching this SQL condition over snake_case columns, "
+                             "e.g. \"email != '' AND rating >= 4 AND query LIKE '%%Crete%%'\"")
+    export.add_argument("--preset", choices=sorted(CONTACT_PRESETS),
+                        help="CSV in the column layout Google Contacts or Outlook imports directly")
+    export.add_argument("--by-quality", action="store_true", help="Best leads first (see quality_weights)")
+    since = export.add_mutually_exclusive_group()
+    since.add_argument("--since-last", action="store_true", help="Only leads added since the previous export")
+    since.add_argument("--since", type=lambda s: date.fromisoformat(s).isoformat(), metavar="YYYY-MM-DD",
+                       help="Only leads added on or after this date")
+    refresh = sub.add_parser("refresh", help="Re-scrape stale leads and record what changed")
+    refresh.add_argument("--older-than", type=parse_age, default=parse_age("90d"),
+                         help="Only leads last checked longer ago than this (e.g. 90d, 2w; default 90d)")
+    merge = sub.add_parser("mailmerge", help="Render every lead through a Jinja2 template (e.g. an outreach email)")
+    merge.add_argument("--template", required=True, help="Template file, e.g. Hello {{ company }}, ... {{ website }}")
+    merge.add_argument("--out", help="Write one file per lead here; without it a combined preview is printed")
+    merge.add_argument("--all", action="store_true", help="Include leads without an email")
+    merge.add_argument("--limit", type=int, default=0, help="Only render the first N leads")
+    send = sub.add_parser("send", help="Email a template to leads over SMTP (opt-in, throttled, never twice)")
+    send.add_argument("--template", required=True, help="Jinja2 template whose first line is 'Subject: ...'")
+    send.add_argument("--limit", type=int, default=0, help="Only send to the first N pending leads")
+    send.add_argument("--confirm", action="store_true", help="Actually send; without it recipients are only listed")
+    combine = sub.add_parser("merge", help="Combine leads files into one, merging duplicates like a live scrape")
+    combine.add_argument("files", nargs="+", help="Leads CSVs to combine (their _emails/_phones/... files too)")
+    combine.add_argument("--out", required=True, help="Combined leads CSV; leads already in it are kept")
+    dd = sub.add_parser("dedupe", help="Report (or merge/delete) leads that look like the same business")
+    dd.add_argument("--by", choices=["domain", "phone", "name", "all"], default="domain",
+                    help="Duplicate signal: website eTLD+1, E.164-normalised phone, similar name and address, "
+                         "or all three in turn")
+    dd.add_argument("--merge", "--auto", action="store_true", help="Fold each group into its oldest lead")
+    dd.add_argument("--delete", action="store_true", help="Delete the rest of each group instead of merging")
+    dd.add_argument("--interactive", action="store_true", help="Ask what to do with each group")
+    ext = sub.add_parser("extract", help="Run email/phone extraction over saved HTML files, no browser needed")
+    ext.add_argument("--from-html", required=True, help="An .html file or a directory of them")
+    parser.set_defaults(grpc_port=int(os.environ.get("GRPC_PORT", 0)))
+    args = parser.parse_args()
+    if hasattr(signal, "SIGUSR1"):  # not on Windows; the pause file works everywhere
+        signal.signal(signal.SIGUSR1, lambda *_: setattr(engine, "paused", True))
+        signal.signal(signal.SIGUSR2, lambda *_: setattr(engine, "paused", False))
+    setup_file_logging(args.log_file, args.log_format, args.log_max_mb, args.log_backups)
+    if args.config:
+        CFG_FILE = args.config.resolve()
+        if not CFG_FILE.exists():
+            sys.exit(f"{args.config} not found.")
+    if args.profile:
+        profiles = load_cfg()["profiles"]
+        if args.profile not in profiles:
+            sys.exit(f"No profile {args.profile!r} in {CFG_FILE.name} (have: {', '.join(profiles) or 'none'}).")
+        CFG_OVERRIDES["profile"] = args.profile
+    if args.db:
+        CFG_OVERRIDES["database_path"] = args.db
+    if args.config or args.profile or args.db:
+        engine.open_db(load_cfg())
+    setup_tracing(load_cfg())
+    if float(engine.cfg["retention_days"] or 0):
+        if engine.cfg["retention_action"] not in RETENTION_ACTIONS:
+            sys.exit(f"Set retention_action to one of {', '.join(RETENTION_ACTIONS)} in config.json first.")
+        engine.apply_retention()
+    if args.pprof or args.memprofile:
+        tracemalloc.start()
+    if args.pprof:
+        serve_debug(args.pprof)
+    if args.memprofile:
+        atexit.register(lambda: Path(args.memprofile).write_text(memory_report(), encoding="utf-8"))
+    if args.cpuprofile:
+        import cProfile
+        profiler = cProfile.Profile()
+        profiler.enable()
+        atexit.register(lambda: (profiler.disable(), profiler.dump_stats(args.cpuprofile)))
 
-    port = int(os.environ.get("PORT", 8000))
+    if getattr(args, "queries", None):
+        CFG_OVERRIDES["queries"] = read_queries(args.queries)
+        if not CFG_OVERRIDES["queries"]:
+            sys.exit(f"No queries in {args.queries}.")
 
-    app.run(host="0.0.0.0", port=port)
+    if args.output:
+        engine.output = sys.stdout if args.output == "-" else open(args.output, "a", encoding="utf-8")
+
+    if args.cmd in ("coordinator", "worker"):
+        cfg = load_cfg()
+        if args.proxy:
+            cfg = {**cfg, "proxy": args.proxy}
+        job = engine.coordinate if args.cmd == "coordinator" else engine.work
+        try:
+            asyncio.run(job(cfg, connect_redis(args.redis)))
+        except KeyboardInterrupt:
+            pass
+    elif args.cmd == "runs":
+        for r in engine.runs:
+            c = r["counters"]
+            print(f"#{r['id']:<4} {r['started_at']}  {r['mode']:<11} {r['status']:<9} "
+                  f"+{c.get('leads_added', 0)} leads, {c.get('with_email', 0)} with email  "
+                  f"{', '.join(r['queries'])[:60]}")
+    elif args.cmd == "stats":
+        found = lead_stats(engine.data, engine.runs)
+        if args.format == "json":
+            print(json.dumps(found, ensure_ascii=False, indent=2))
+        else:
+            print_stats(found)
+    elif args.cmd == "search":
+        print_leads(search_leads(engine.data, args.text, args.limit))
+    elif args.cmd == "list":
+        rows = filter_leads(engine.data, args.has_email, args.location, args.category)
+        print_leads(rows[args.offset:args.offset + args.limit] if args.limit else rows[args.offset:], args.format)
+        if args.format == "table":
+            print(f"-- {min(len(rows), args.offset + 1)}-{min(len(rows), args.offset + (args.limit or len(rows)))} "
+                  f"of {len(rows)} leads", file=sys.stderr)
+    elif args.cmd == "extract":
+        src = Path(args.from_html)
+        for path in sorted(src.glob("**/*.htm*")) if src.is_dir() else [src]:
+            found = extract(path.read_text(encoding="utf-8", errors="replace"))
+            print(json.dumps({"file": str(path), "email": (found["emails"] or [""])[0], "emails": found["emails"],
+                              "phones": [{"phone": p, "type": phone_type(p)} for p in found["phones"]],
+                              "socials": [url for _, url in found["socials"]], **found["channels"]},
+                             ensure_ascii=False))
+    elif args.cmd == "mailmerge":
+        leads = [r for r in engine.suppress(engine.data, sync=True) if args.all or r.get("Email")]
+        print(mail_merge(args.template, leads[:args.limit] if args.limit else leads, args.out))
+    elif args.cmd == "send":
+        send_campaign(load_cfg(), engine, args.template, args.limit, args.confirm)
+    elif args.cmd == "merge":
+        if missing := [f for f in args.files if not Path(f).exists()]:
+            parser.error(f"not found: {', '.join(missing)}")
+        engine.open_db({**load_cfg(), "database_path": str(Path(args.out).resolve())})
+        engine.merge_files([Path(f).resolve() for f in args.files])
+    elif args.cmd == "dedupe":
+        cfg, before = load_cfg(), len(engine.changes)
+        for by in ["domain", "phone", "name"] if args.by == "all" else [args.by]:
+            groups = fuzzy_duplicates(engine.data, float(cfg["name_similarity"])) if by == "name" else \
+                find_duplicates(engine.data, lambda r: duplicate_key(by, r, cfg))
+            if not engine.dedupe(groups, args.merge, args.interactive, args.delete, by):
+                break
+        if len(engine.changes) > before:
+            print(f"{len(engine.changes) - before} merged/deleted leads are logged in {engine.changes_file.name}.")
+    elif args.cmd == "retry-failed":
+        asyncio.run(engine.retry_failed(load_cfg()))
+    elif args.cmd == "export":
+        if args.preset and args.out.lower().endswith((".xlsx", ".parquet")):
+            parser.error("--preset writes CSV; use a .csv --out")
+        if args.sql:
+            try:
+                sql_filter([], args.sql)
+            except sqlite3.Error as e:
+                parser.error(f"--sql: {e}")
+        engine.export(args.out, engine.meta.get("last_export", "") if args.since_last else (args.since or ""),
+                      args.collapse_chains or engine.cfg["collapse_chains"], args.preset or "", args.sql,
+                      args.by_quality)
+    elif args.cmd == "refresh":
+        asyncio.run(engine.refresh(load_cfg(), args.older_than))
+    elif args.cmd == "airtable":
+        push_airtable(load_cfg(), engine.suppress(engine.data, sync=True))
+    elif args.cmd == "push":
+        cfg = load_cfg() | ({"webhook_batch_size": args.batch_size} if args.batch_size else {})
+        if not (args.url or cfg["webhook_url"]):
+            parser.error("give --url or set webhook_url")
+        since = engine.meta.get("last_webhook_push", "") if args.since_last else ""
+        push_webhook(cfg, engine.suppress([r for r in engine.data if not since or (r.get("Added At") or "") > since],
+                                          sync=True), args.url)
+        engine.meta["last_webhook_push"] = datetime.now().isoformat(timespec="seconds")
+        engine.save()
+    elif args.cmd == "geocode":
+        cfg = load_cfg()
+        if not cfg.get("geocoder"):
+            sys.exit("Set geocoder to nominatim or google in config.json first.")
+        geocode_missing(cfg, engine.data)
+        engine.save()
+    elif args.cmd == "verify":
+        cfg = load_cfg()
+        if cfg["email_verifier"] not in VERIFIERS:
+            sys.exit(f"Set email_verifier to one of {', '.join(VERIFIERS)} in config.json first.")
+        engine.verify_emails(cfg)
+    elif args.cmd == "enrich-companies":
+        cfg = load_cfg()
+        if cfg["company_enricher"] not in ENRICHERS:
+            sys.exit(f"Set company_enricher to one of {', '.join(ENRICHERS)} in config.json first.")
+        engine.enrich_companies(cfg, args.all)
+    elif args.cmd == "init":
+        init_wizard(args.out or CFG_FILE)
+    elif args.cmd == "scrape":
+        asyncio.run(engine.run(load_cfg()))
+    elif args.cmd == "scrape-websites":
+        asyncio.run(engine.scrape_websites(load_cfg(), read_websites(args.input)))
+    else:
+        if args.grpc_port:
+            serve_grpc(args.grpc_port)
+        port = int(os.environ.get("PORT", 8000))
+        app.run(host="0.0.0.0", port=port)
+    if engine.degraded:
+        sys.exit(2)